@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var artifactsDir string
+
+// harnessArgv is the command line the last harness was started with,
+// wrappers included; nil if it did not get to start.
+var harnessArgv []string
+
+// shellQuote quotes s for /bin/sh.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "'\\''", -1) + "'"
+}
+
+// saveArtifacts collects everything needed to look at a failed run
+// without rerunning the survey into artifactsDir/<harness><suffix>-run<n>:
+// the harness source and binary, its stdout and stderr, its environment,
+// the toolchain it was built with and repro.sh, which runs the binary
+// again through the same wrappers in the same directory with the same
+// environment. A sandbox is copied along, since the next survey removes
+// it.
+func saveArtifacts(cwd string, test string, runDir string, env []string, stdout *os.File) {
+	if artifactsDir == "" || isInterrupted() {
+		return
+	}
+	name := path.Base(test)
+	if strings.HasSuffix(name, ".go") {
+		name = name[:len(name)-len(".go")]
+	}
+	// take the next free run number, so that neither a rerun nor a later
+	// survey or replay overwrites an earlier bundle
+	name += currentToolchain.suffix()
+	var dir string
+	for n := 1; ; n++ {
+		dir = path.Join(cwd, artifactsDir, name+"-run"+strconv.Itoa(n))
+		if _, err := os.Stat(dir); err != nil {
+			break
+		}
+	}
+	err := os.MkdirAll(dir, 0764)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "saving artifacts of %s: %s\n", test, err)
+		return
+	}
+
+	copies := [][2]string{
+		{test, path.Base(test)},
+		{path.Join(cwd, "work", "test"), "test"},
+		{test + ".output", "stderr"},
+	}
+	if stdout != nil {
+		copies = append(copies, [2]string{stdout.Name(), "stdout"})
+	}
+	if interleave {
+		copies = append(copies, [2]string{test + ".log", "log"})
+	}
+	for _, c := range copies {
+		if err := copyFile(path.Join(dir, c[1]), c[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "saving artifacts of %s: %s\n", test, err)
+		}
+	}
+	os.Chmod(path.Join(dir, "test"), 0755)
+	collectCore(runDir, dir, test+".output")
+
+	if sandbox {
+		sandboxCopy := path.Join(dir, "sandbox")
+		if err := copyTree(sandboxCopy, runDir); err != nil {
+			fmt.Fprintf(os.Stderr, "saving artifacts of %s: %s\n", test, err)
+		}
+		moved := make([]string, len(env))
+		for i, kv := range env {
+			moved[i] = strings.Replace(kv, runDir, sandboxCopy, -1)
+		}
+		runDir, env = sandboxCopy, moved
+	}
+
+	ioutil.WriteFile(path.Join(dir, "env"), []byte(strings.Join(env, "\n")+"\n"), 0664)
+
+	version := bytes.NewBufferString("")
+	fmt.Fprintf(version, "toolchain %s\n", currentToolchain.name)
+	if data, err := ioutil.ReadFile(path.Join(GOROOT, "VERSION")); err == nil {
+		fmt.Fprintf(version, "VERSION %s\n", strings.TrimSpace(string(data)))
+	}
+	if stamp, err := toolchainStamp(); err == nil {
+		version.WriteString(stamp)
+	}
+	ioutil.WriteFile(path.Join(dir, "toolchain"), version.Bytes(), 0664)
+
+	quoted := make([]string, len(env))
+	for i, kv := range env {
+		quoted[i] = shellQuote(kv)
+	}
+	binary := path.Join(cwd, "work", "test")
+	argv := harnessArgv
+	if argv == nil {
+		argv = append([]string{binary}, harnessArgs()...)
+	}
+	repro := "#!/bin/sh\ncd " + shellQuote(runDir) + " && exec env -i " + strings.Join(quoted, " ")
+	for _, arg := range argv {
+		if arg == binary {
+			arg = path.Join(dir, "test")
+		}
+		repro += " " + shellQuote(arg)
+	}
+	repro += " </dev/null\n"
+	ioutil.WriteFile(path.Join(dir, "repro.sh"), []byte(repro), 0775)
+}
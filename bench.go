@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BASELINE string = "base"
+	LOADED   string = "load"
+)
+
+type benchSamples map[string][]float64
+
+func (bs benchSamples) add(name string, nsPerOp float64) {
+	bs[name] = append(bs[name], nsPerOp)
+}
+
+// parseBenchOutput collects the ns/op figures that testing.RunBenchmarks
+// printed for name, one line per goroutine that ran the benchmark.
+func parseBenchOutput(filename string, name string) ([]float64, os.Error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]float64, 0)
+	for _, line := range strings.Split(string(data), "\n", -1) {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != name {
+			continue
+		}
+		for i := 2; i < len(fields); i++ {
+			if fields[i] != "ns/op" {
+				continue
+			}
+			ns, err := strconv.Atof64(fields[i-1])
+			if err != nil {
+				return nil, err
+			}
+			samples = append(samples, ns)
+		}
+	}
+	return samples, nil
+}
+
+func runBenchHarness(testMain *TestMain, bench string, goroutines int, nthTime int) ([]float64, os.Error) {
+	filename := "bTest" + testMain.underscorePkgName() + "_" + bench + "_" + strconv.Itoa(goroutines) + "_" + strconv.Itoa(nthTime) + ".go"
+	err := writeSingleTest(testMain, bench, 1, goroutines, filename)
+	if err != nil {
+		return nil, err
+	}
+	out, err := os.Open(filename+".bench", os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	err = executeSingleTest(filename, testMain.pkgName, out, nil)
+	out.Close()
+	if err != nil {
+		return nil, err
+	}
+	samples, err := parseBenchOutput(filename+".bench", testMain.pkgName+"."+bench)
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, os.NewError(filename + " printed no ns/op results")
+	}
+	// leave nothing behind for generateReport to pick up
+	os.Remove(filename + ".bench")
+	os.Remove(filename + ".6")
+	os.Remove(filename)
+	return samples, nil
+}
+
+// loadBenchHistory returns the samples of the most recent run stored in
+// the history file, keyed by kind and then by benchmark name.
+func loadBenchHistory(filename string) (map[string]benchSamples, os.Error) {
+	history := map[string]benchSamples{BASELINE: make(benchSamples), LOADED: make(benchSamples)}
+	file, err := os.Open(filename, os.O_RDONLY, 0)
+	if err != nil {
+		// no history yet
+		return history, nil
+	}
+	defer file.Close()
+
+	latest := make(map[string]int64)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == os.EOF {
+				break
+			}
+			return nil, err
+		}
+		fields := strings.Split(strings.TrimSpace(line), "\t", -1)
+		if len(fields) != 4 {
+			continue
+		}
+		runId, err := strconv.Atoi64(fields[0])
+		if err != nil {
+			continue
+		}
+		ns, err := strconv.Atof64(fields[3])
+		if err != nil {
+			continue
+		}
+		kind, name := fields[1], fields[2]
+		if history[kind] == nil {
+			continue
+		}
+		key := kind + "\t" + name
+		if runId > latest[key] {
+			latest[key] = runId
+			history[kind][name] = nil
+		}
+		if runId == latest[key] {
+			history[kind].add(name, ns)
+		}
+	}
+	return history, nil
+}
+
+func appendBenchHistory(filename string, runId int64, kind string, samples benchSamples) os.Error {
+	file, err := os.Open(filename, os.O_WRONLY|os.O_CREAT|os.O_APPEND, 0664)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for name, values := range samples {
+		for _, ns := range values {
+			fmt.Fprintf(file, "%d\t%s\t%s\t%.2f\n", runId, kind, name, ns)
+		}
+	}
+	return nil
+}
+
+func formatBenchSummary(samples []float64) string {
+	if len(samples) == 0 {
+		return "~"
+	}
+	m := mean(samples)
+	spread := 0.0
+	if m != 0 {
+		spread = 100 * stddev(samples) / m
+	}
+	return fmt.Sprintf("%.0f ±%2.0f%%", m, spread)
+}
+
+// compareBench prints a benchstat style line comparing before and after
+// and reports whether after is a significant slowdown.
+func compareBench(name string, before, after []float64) bool {
+	before = removeOutliers(before)
+	after = removeOutliers(after)
+	if len(before) == 0 || len(after) == 0 {
+		fmt.Printf("%-50s %20s %20s\n", name, formatBenchSummary(before), formatBenchSummary(after))
+		return false
+	}
+	p := mannWhitneyU(before, after)
+	if p >= benchAlpha {
+		fmt.Printf("%-50s %20s %20s %10s (p=%.3f n=%d+%d)\n", name, formatBenchSummary(before), formatBenchSummary(after), "~", p, len(before), len(after))
+		return false
+	}
+	delta := 100 * (mean(after) - mean(before)) / mean(before)
+	fmt.Printf("%-50s %20s %20s %+9.2f%% (p=%.3f n=%d+%d)\n", name, formatBenchSummary(before), formatBenchSummary(after), delta, p, len(before), len(after))
+	return delta > benchDelta
+}
+
+func (bs benchSamples) sortedNames() []string {
+	names := make([]string, 0, len(bs))
+	for name := range bs {
+		names = append(names, name)
+	}
+	sort.SortStrings(names)
+	return names
+}
+
+// collectBench runs every benchmark -reruns times in one goroutine and in
+// -iters goroutines and returns the samples keyed by kind.
+func collectBench(testMains []*TestMain) map[string]benchSamples {
+	blackList := loadBlackList()
+	current := map[string]benchSamples{BASELINE: make(benchSamples), LOADED: make(benchSamples)}
+	for _, testMain := range testMains {
+		if listContains(blackList, testMain.pkgName) {
+			continue
+		}
+		for _, bench := range testMain.benchmarks {
+			fullName := testMain.pkgName + "." + bench
+			if listContains(blackList, fullName) {
+				continue
+			}
+			fmt.Printf("%s", fullName)
+			var failed os.Error
+			for i := 0; i < reruns; i++ {
+				base, err := runBenchHarness(testMain, bench, 1, i)
+				if err != nil {
+					failed = err
+					break
+				}
+				load, err := runBenchHarness(testMain, bench, iters, i)
+				if err != nil {
+					failed = err
+					break
+				}
+				for _, ns := range base {
+					current[BASELINE].add(fullName, ns)
+				}
+				for _, ns := range load {
+					current[LOADED].add(fullName, ns)
+				}
+			}
+			if failed != nil {
+				fmt.Printf(", failed: %s\n", failed)
+			} else {
+				fmt.Printf(", done\n")
+			}
+		}
+	}
+	return current
+}
+
+func generateBenchReport(testMains []*TestMain) os.Error {
+	fmt.Printf("BENCH START\n")
+
+	history, err := loadBenchHistory(benchHistory)
+	if err != nil {
+		return err
+	}
+
+	current := collectBench(testMains)
+
+	regressions := make([]string, 0)
+
+	fmt.Printf("\n%-50s %20s %20s\n", "name", "1 goroutine ns/op", strconv.Itoa(iters)+" goroutines ns/op")
+	for _, name := range current[LOADED].sortedNames() {
+		if compareBench(name, current[BASELINE][name], current[LOADED][name]) {
+			regressions = append(regressions, name+" (contention)")
+		}
+	}
+
+	fmt.Printf("\n%-50s %20s %20s\n", "name", "previous ns/op", "current ns/op")
+	for _, name := range current[LOADED].sortedNames() {
+		previous, ok := history[LOADED][name]
+		if !ok {
+			continue
+		}
+		if compareBench(name, previous, current[LOADED][name]) {
+			regressions = append(regressions, name+" (since previous run)")
+		}
+	}
+
+	runId := time.Seconds()
+	for _, kind := range []string{BASELINE, LOADED} {
+		err = appendBenchHistory(benchHistory, runId, kind, current[kind])
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("\n")
+	for _, r := range regressions {
+		fmt.Printf("REGRESSION: %s\n", r)
+	}
+	fmt.Printf("BENCH DONE\n")
+	return nil
+}
+
+// compareToolchainBench compares the loaded samples of every toolchain
+// with those of the first one and reports the benchmarks that got
+// significantly slower.
+func compareToolchainBench(tcs []*toolchain, samples []map[string]benchSamples) {
+	regressions := make([]string, 0)
+	first := samples[0][LOADED]
+	for i := 1; i < len(tcs); i++ {
+		fmt.Printf("\n%-50s %20s %20s\n", "name", tcs[0].name+" ns/op", tcs[i].name+" ns/op")
+		for _, name := range first.sortedNames() {
+			after, ok := samples[i][LOADED][name]
+			if !ok {
+				continue
+			}
+			if compareBench(name, first[name], after) {
+				regressions = append(regressions, name+" ("+tcs[i].name+" against "+tcs[0].name+")")
+			}
+		}
+	}
+
+	fmt.Printf("\n")
+	for _, r := range regressions {
+		fmt.Printf("REGRESSION: %s\n", r)
+	}
+}
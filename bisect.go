@@ -0,0 +1,279 @@
+package main
+
+import (
+	"exec"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"strings"
+)
+
+var (
+	bisectSrc        string
+	bisectGood       string
+	bisectBad        string
+	bisectConfidence float64
+	bisectMaxRuns    int
+)
+
+func bisectFlags() {
+	flag.StringVar(&bisectSrc, "src", "", "Go source checkout (a git repository) to build the toolchains from")
+	flag.StringVar(&bisectGood, "good", "", "commit on which the harness passes")
+	flag.StringVar(&bisectBad, "bad", "HEAD", "commit on which the harness fails")
+	flag.Float64Var(&bisectConfidence, "confidence", 0.99, "confidence required before a commit is called good")
+	flag.IntVar(&bisectMaxRuns, "maxruns", 1000, "most runs of the harness on one commit")
+}
+
+// runIn runs argv in dir with env and stdout, and fails unless it exits
+// with status 0.
+func runIn(dir string, env []string, stdout *os.File, argv ...string) os.Error {
+	name, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	p, err := os.StartProcess(name, argv, env, dir, []*os.File{nil, stdout, os.Stderr})
+	if err != nil {
+		return err
+	}
+	trackChild(p.Pid, false)
+	waitMsg, err := p.Wait(0)
+	untrackChild(p.Pid)
+	if err != nil {
+		return err
+	}
+	if waitMsg.ExitStatus() != 0 {
+		return os.NewError(strings.Join(argv, " ") + ": " + waitMsg.String())
+	}
+	return nil
+}
+
+// gitOutput runs git in the bisect checkout and returns what it printed.
+func gitOutput(args ...string) (string, os.Error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	done := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		r.Close()
+		done <- data
+	}()
+	err = runIn(bisectSrc, os.Environ(), w, append([]string{"git"}, args...)...)
+	w.Close()
+	data := <-done
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// buildToolchain builds the toolchain, the installed packages and the
+// test build of pkgName in the checkout.
+func buildToolchain(pkgName string) os.Error {
+	env := []string{
+		"PATH=" + path.Join(bisectSrc, "bin") + ":" + os.Getenv("PATH"),
+		"GOROOT=" + bisectSrc,
+		"GOBIN=" + path.Join(bisectSrc, "bin"),
+		"HOME=" + os.Getenv("HOME"),
+	}
+	err := runIn(path.Join(bisectSrc, "src"), env, os.Stderr, "./make.bash")
+	if err != nil {
+		return err
+	}
+	pkgDir := path.Join(bisectSrc, "src", "pkg", pkgName)
+	files, err := ioutil.ReadDir(pkgDir)
+	if err != nil {
+		return err
+	}
+	testFiles := make([]string, 0)
+	for _, f := range files {
+		if f.IsRegular() && strings.HasSuffix(f.Name, "_test.go") {
+			testFiles = append(testFiles, f.Name)
+		}
+	}
+	// the same two steps gotest uses to build the test package
+	err = runIn(pkgDir, env, os.Stderr, "make", "testpackage-clean")
+	if err != nil {
+		return err
+	}
+	return runIn(pkgDir, env, os.Stderr, "make", "testpackage", "GOTESTFILES="+strings.Join(testFiles, " "))
+}
+
+// runsForConfidence is how many passing runs in a row make it unlikely
+// enough, at the failure rate seen on the bad commit, that a commit only
+// looks good by chance.
+func runsForConfidence(rate float64) int {
+	if rate >= 1 {
+		return 1
+	}
+	n := int(math.Ceil(math.Log(1-bisectConfidence) / math.Log(1-rate)))
+	if n > bisectMaxRuns {
+		n = bisectMaxRuns
+	}
+	return n
+}
+
+// errSkip is returned by tryCommit for a commit that does not build; like
+// git bisect skip, such a commit tells nothing either way.
+var errSkip = os.NewError("does not build")
+
+// tryCommit builds commit and runs the harness until it fails or has
+// passed runs times. It returns the number of runs and failures.
+func tryCommit(cwd string, commit string, harness string, pkgName string, runs int, stopOnFailure bool) (int, int, os.Error) {
+	_, err := gitOutput("checkout", "-q", commit)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = buildToolchain(pkgName)
+	if err == nil {
+		useToolchain(&toolchain{"bisect", bisectSrc})
+		err = prepareWorkspace(cwd)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", commit, err)
+		return 0, 0, errSkip
+	}
+	failures := 0
+	for i := 0; i < runs; i++ {
+		if isInterrupted() {
+			return i, failures, os.NewError("interrupted")
+		}
+		if executeHarness(harness, pkgName) != nil {
+			failures++
+			if stopOnFailure {
+				return i + 1, failures, nil
+			}
+		}
+	}
+	return runs, failures, nil
+}
+
+// pickCommit returns the commit between lo and hi closest to the middle
+// that was not skipped, or -1 if all of them were.
+func pickCommit(lo, hi int, skipped map[int]bool) int {
+	mid := (lo + hi) / 2
+	for d := 0; mid-d > lo || mid+d < hi; d++ {
+		if mid-d > lo && !skipped[mid-d] {
+			return mid - d
+		}
+		if mid+d < hi && !skipped[mid+d] {
+			return mid + d
+		}
+	}
+	return -1
+}
+
+// bisect finds the first commit between -good and -bad on which harness
+// fails. A commit is bad as soon as the harness fails on it once; it is
+// only called good after enough passing runs that, at the failure rate
+// measured on the bad commit, a bad commit would have failed with
+// probability -confidence. -good must pass that test itself. Commits that
+// do not build are skipped.
+func bisect(cwd string, harness string) os.Error {
+	_, pkgName, err := harnessPackage(harness)
+	if err != nil {
+		return err
+	}
+	if bisectSrc == "" || bisectGood == "" {
+		return os.NewError("-src and -good are required")
+	}
+	if !path.IsAbs(bisectSrc) {
+		bisectSrc = path.Join(cwd, bisectSrc)
+	}
+	bisectSrc = path.Clean(bisectSrc)
+
+	// go back to the branch the checkout was on, or to its commit if it
+	// was on none
+	origHead, err := gitOutput("symbolic-ref", "-q", "HEAD")
+	if err == nil && strings.HasPrefix(origHead, "refs/heads/") {
+		origHead = origHead[len("refs/heads/"):]
+	} else {
+		origHead, err = gitOutput("rev-parse", "HEAD")
+		if err != nil {
+			return err
+		}
+	}
+	defer gitOutput("checkout", "-q", origHead)
+
+	list, err := gitOutput("rev-list", "--reverse", "--first-parent", bisectGood+".."+bisectBad)
+	if err != nil {
+		return err
+	}
+	commits := strings.Fields(list)
+	if len(commits) == 0 {
+		return os.NewError("no commits between " + bisectGood + " and " + bisectBad)
+	}
+
+	runs, failures, err := tryCommit(cwd, commits[len(commits)-1], harness, pkgName, reruns, false)
+	if err == errSkip {
+		return os.NewError(bisectBad + " does not build")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s (bad): %d of %d runs failed\n", commits[len(commits)-1][:12], failures, runs)
+	if failures == 0 {
+		return os.NewError("the harness does not fail on " + bisectBad + ", raise -reruns")
+	}
+	rate := float64(failures) / float64(runs)
+	needed := runsForConfidence(rate)
+	fmt.Printf("failure rate %.3f, %d passing runs needed to call a commit good\n", rate, needed)
+
+	runs, failures, err = tryCommit(cwd, bisectGood, harness, pkgName, needed, true)
+	if err == errSkip {
+		return os.NewError(bisectGood + " does not build")
+	}
+	if err != nil {
+		return err
+	}
+	if failures > 0 {
+		return os.NewError(fmt.Sprintf("the harness fails on %s as well, after %d runs", bisectGood, runs))
+	}
+	fmt.Printf("%s (good): %d runs passed\n", bisectGood, runs)
+
+	// commits[lo] is good (or lo is -good itself), commits[hi] is bad
+	lo, hi := -1, len(commits)-1
+	confidence := 1.0
+	skipped := make(map[int]bool)
+	for hi-lo > 1 {
+		mid := pickCommit(lo, hi, skipped)
+		if mid < 0 {
+			fmt.Printf("the first bad commit could be any of:\n")
+			for i := lo + 1; i <= hi; i++ {
+				subject, _ := gitOutput("log", "-1", "--format=%s", commits[i])
+				fmt.Printf("\t%s %s\n", commits[i], subject)
+			}
+			return nil
+		}
+		runs, failures, err := tryCommit(cwd, commits[mid], harness, pkgName, needed, true)
+		if err == errSkip {
+			fmt.Printf("%s: skipped, does not build\n", commits[mid][:12])
+			skipped[mid] = true
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if failures > 0 {
+			fmt.Printf("%s: bad, failed after %d runs\n", commits[mid][:12], runs)
+			hi = mid
+		} else {
+			c := 1 - math.Pow(1-rate, float64(runs))
+			fmt.Printf("%s: good, %d runs passed (confidence %.3f)\n", commits[mid][:12], runs, c)
+			confidence *= c
+			lo = mid
+		}
+	}
+
+	subject, _ := gitOutput("log", "-1", "--format=%s", commits[hi])
+	fmt.Printf("first bad commit: %s %s\n", commits[hi], subject)
+	fmt.Printf("confidence: %.3f\n", confidence)
+	return nil
+}
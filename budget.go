@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	budgetFlag string
+	minRuns    int
+)
+
+func budgetFlags() {
+	flag.StringVar(&budgetFlag, "budget", "", "total time to spend, e.g. 2h or 90m; reruns are spread over the tests by their history")
+	flag.IntVar(&minRuns, "minruns", 3, "runs every test gets with -budget")
+}
+
+// parseBudget parses a duration such as "2h", "90m", "1h30m" or "45s"
+// into nanoseconds.
+func parseBudget(s string) (int64, os.Error) {
+	units := map[byte]int64{'h': 3600e9, 'm': 60e9, 's': 1e9}
+	var total int64
+	start := 0
+	for i := 0; i < len(s); i++ {
+		unit, ok := units[s[i]]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atof64(s[start:i])
+		if err != nil {
+			return 0, os.NewError("bad budget " + s)
+		}
+		total += int64(n * float64(unit))
+		start = i + 1
+	}
+	if start != len(s) || total <= 0 {
+		return 0, os.NewError("bad budget " + s + ", want e.g. 2h or 90m")
+	}
+	return total, nil
+}
+
+// budgetMinRuns is the number of runs every test gets with -budget: at
+// least one under every condition of the sweep.
+func budgetMinRuns() int {
+	if n := len(sweepConditions()); n > minRuns {
+		return n
+	}
+	return minRuns
+}
+
+// harnessTime is how long the last harness ran, without compiling and
+// linking it; 0 if it did not get to run.
+var harnessTime int64
+
+// testHistory is what earlier surveys learned about one test.
+type testHistory struct {
+	runs, failures int
+	durations      []float64
+}
+
+// loadHistory reads the results of earlier surveys: one line per run
+// with the test, its result and how long it took in nanoseconds.
+func loadHistory(filename string) (map[string]*testHistory, os.Error) {
+	history := make(map[string]*testHistory)
+	file, err := os.Open(filename, os.O_RDONLY, 0)
+	if err != nil {
+		// no history yet
+		return history, nil
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == os.EOF {
+				break
+			}
+			return nil, err
+		}
+		fields := strings.Split(strings.TrimSpace(line), "\t", -1)
+		if len(fields) != 3 {
+			continue
+		}
+		ns, err := strconv.Atof64(fields[2])
+		if err != nil {
+			continue
+		}
+		h := history[fields[0]]
+		if h == nil {
+			h = new(testHistory)
+			history[fields[0]] = h
+		}
+		switch fields[1] {
+		case "passed":
+			h.runs++
+		case "failed", "resource-limit":
+			h.runs++
+			h.failures++
+		default:
+			continue
+		}
+		h.durations = append(h.durations, ns)
+	}
+	return history, nil
+}
+
+// weight is how much of the spare budget a test deserves: the variance
+// p(1-p) of its outcome, with the failure rate p estimated so that an
+// unknown test counts as likely to fail, plus the relative spread of its
+// run times, which is high for tests that hang or slow down now and
+// then.
+func (h *testHistory) weight() float64 {
+	p := float64(h.failures+1) / float64(h.runs+2)
+	w := p * (1 - p)
+	if m := mean(h.durations); m > 0 {
+		w += stddev(h.durations) / m
+	}
+	return w
+}
+
+// planRuns spreads budget nanoseconds over the tests in names. Every test
+// gets budgetMinRuns runs; the time left is shared out by weight, and a test
+// gets as many runs of its share as its mean run time allows, at most
+// -maxreruns. Tests without a history are assumed to take as long as the
+// average test.
+func planRuns(names []string, history map[string]*testHistory, budget int64) map[string]int {
+	all := make([]float64, 0)
+	for _, h := range history {
+		all = append(all, h.durations...)
+	}
+	defaultDuration := mean(all)
+	if defaultDuration == 0 {
+		defaultDuration = 10e9
+	}
+
+	duration := make(map[string]float64)
+	weights := make(map[string]float64)
+	spare := float64(budget)
+	totalWeight := 0.0
+	for _, name := range names {
+		h := history[name]
+		if h == nil {
+			h = new(testHistory)
+		}
+		duration[name] = defaultDuration
+		if m := mean(h.durations); m > 0 {
+			duration[name] = m
+		}
+		weights[name] = h.weight()
+		totalWeight += weights[name]
+		spare -= float64(budgetMinRuns()) * duration[name]
+	}
+
+	plan := make(map[string]int)
+	for _, name := range names {
+		runs := budgetMinRuns()
+		if spare > 0 && totalWeight > 0 {
+			runs += int(math.Floor(spare * weights[name] / totalWeight / duration[name]))
+		}
+		if runs > maxReruns && maxReruns > budgetMinRuns() {
+			runs = maxReruns
+		}
+		plan[name] = runs
+	}
+	return plan
+}
+
+// budgetPlan plans the runs of a survey of testMains within -budget.
+func budgetPlan(testMains []*TestMain, blackList []string, budget int64) (map[string]int, os.Error) {
+	history, err := loadHistory(currentToolchain.historyFile())
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, testMain := range testMains {
+		if listContains(blackList, testMain.pkgName) {
+			continue
+		}
+		for _, list := range [][]string{testMain.tests, testMain.benchmarks, testMain.examples, []string{"head"}} {
+			for _, name := range list {
+				fullName := testMain.pkgName + "." + name
+				if !listContains(blackList, fullName) {
+					names = append(names, fullName)
+				}
+			}
+		}
+	}
+	plan := planRuns(names, history, budget)
+	fmt.Printf("budget %s planned for %d tests\n", formatDuration(budget), len(names))
+	return plan, nil
+}
+
+// withinBudget tells whether a run beyond budgetMinRuns may still start.
+func (state *surveyState) withinBudget() bool {
+	return time.Nanoseconds()-state.prog.start < state.budget
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// checkpoint records every finished (test, rerun) pair of a survey as it
+// completes, so that an interrupted survey can be resumed with -resume.
+type checkpoint struct {
+	done       map[string]string
+	conditions map[string]string
+	file       *os.File
+}
+
+func checkpointKey(fullName string, rerun int) string {
+	return fullName + "\t" + strconv.Itoa(rerun)
+}
+
+// openCheckpoint starts a new checkpoint file, or with resume reads the
+// runs finished so far and appends to it.
+func openCheckpoint(filename string, resume bool) (*checkpoint, os.Error) {
+	c := &checkpoint{done: make(map[string]string), conditions: make(map[string]string)}
+	if !resume {
+		file, err := os.Open(filename, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0664)
+		if err != nil {
+			return nil, err
+		}
+		c.file = file
+		return c, nil
+	}
+
+	file, err := os.Open(filename, os.O_RDONLY, 0)
+	if err == nil {
+		reader := bufio.NewReader(file)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				// a line without newline was cut short by the interruption
+				break
+			}
+			fields := strings.Split(strings.TrimSpace(line), "\t", -1)
+			if len(fields) < 3 || fields[2] == "interrupted" {
+				continue
+			}
+			key := fields[0] + "\t" + fields[1]
+			c.done[key] = fields[2]
+			c.conditions[key] = "default"
+			if len(fields) > 3 {
+				c.conditions[key] = fields[3]
+			}
+		}
+		file.Close()
+	}
+	file, err = os.Open(filename, os.O_WRONLY|os.O_CREAT|os.O_APPEND, 0664)
+	if err != nil {
+		return nil, err
+	}
+	c.file = file
+	return c, nil
+}
+
+// lookup returns the result of a finished run and the condition it ran
+// under.
+func (c *checkpoint) lookup(fullName string, rerun int) (string, string, bool) {
+	key := checkpointKey(fullName, rerun)
+	result, ok := c.done[key]
+	return result, c.conditions[key], ok
+}
+
+func (c *checkpoint) record(fullName string, rerun int, result string) {
+	fmt.Fprintf(c.file, "%s\t%d\t%s\t%s\n", fullName, rerun, result, currentCondition.name)
+	c.file.Sync()
+}
+
+func (c *checkpoint) close() {
+	c.file.Close()
+}
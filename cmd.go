@@ -0,0 +1,344 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type command struct {
+	name        string
+	args        string
+	short       string
+	long        string
+	needsGOROOT bool
+	flags       func()
+	run         func(cwd string, args []string) os.Error
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		&command{
+			name:        "prepare",
+			short:       "build or update the stress workspace",
+			long:        "Prepare copies the installed packages and the test build of every package\ninto go.gostress and each package's testdata into work/pkgdata.",
+			needsGOROOT: true,
+			flags:       toolchainFlag,
+			run:         runPrepare,
+		},
+		&command{
+			name:        "list",
+			short:       "list the discovered tests, benchmarks and examples",
+			long:        "List prints every test, benchmark and example gostress would run.",
+			needsGOROOT: true,
+			flags: func() {
+				blacklistFlag()
+				filterFlags()
+				profileFlags()
+			},
+			run: runList,
+		},
+		&command{
+			name:        "run",
+			short:       "generate go.go, which runs all packages in one program",
+			long:        "Run generates go.go, a program that runs the tests and benchmarks of every\npackage in its own goroutine, -iters times.",
+			needsGOROOT: true,
+			flags: func() {
+				itersFlag()
+				filterFlags()
+				profileFlags()
+			},
+			run: runRunner,
+		},
+		&command{
+			name:        "survey",
+			short:       "run each test separately and write the HTML report",
+			long:        "Survey runs every test, benchmark and example in its own harness of -iters\ngoroutines, -reruns times under every combination of the sweep flags, and\nthen writes the report to report/. With -toolchains every toolchain is\nsurveyed in turn and the results are compared in compare.txt.",
+			needsGOROOT: true,
+			flags: func() {
+				itersFlag()
+				rerunsFlag()
+				harnessFlags()
+				sweepFlags()
+				blacklistFlag()
+				filterFlags()
+				profileFlags()
+				toolchainFlag()
+				ciFlags()
+				adaptiveFlags()
+				budgetFlags()
+				flag.BoolVar(&resume, "resume", false, "skip the runs an interrupted survey already finished")
+			},
+			run: runSurvey,
+		},
+		&command{
+			name:  "report",
+			short: "regenerate the HTML report from stored results",
+			long:  "Report rebuilds report/ from the harness sources and outputs left behind\nby the last survey, without running anything.",
+			flags: func() {
+				blacklistFlag()
+				ciFlags()
+			},
+			run: runReport,
+		},
+		&command{
+			name:        "replay",
+			args:        "harness.go...",
+			short:       "run generated harnesses again",
+			long:        "Replay compiles and runs the given harnesses, generated by an earlier\nsurvey, -reruns times each.",
+			needsGOROOT: true,
+			flags: func() {
+				rerunsFlag()
+				harnessFlags()
+				profileFlags()
+			},
+			run: runReplay,
+		},
+		&command{
+			name:        "bench",
+			short:       "compare benchmarks under load with a baseline",
+			long:        "Bench runs every benchmark in one goroutine and in -iters goroutines and\ncompares the results with each other and with the previous run.",
+			needsGOROOT: true,
+			flags: func() {
+				itersFlag()
+				rerunsFlag()
+				harnessFlags()
+				benchFlags()
+				blacklistFlag()
+				filterFlags()
+				profileFlags()
+				toolchainFlag()
+			},
+			run: runBench,
+		},
+		&command{
+			name:  "bisect",
+			args:  "harness.go",
+			short: "find the commit that made a harness fail",
+			long:  "Bisect builds the toolchain at commits of the Go checkout -src between\n-good and -bad and runs the harness on each until it fails or has passed\noften enough to reach -confidence, and reports the first bad commit. The\nfailure rate is first measured with -reruns runs on -bad.",
+			flags: func() {
+				rerunsFlag()
+				harnessFlags()
+				bisectFlags()
+			},
+			run: runBisect,
+		},
+		&command{
+			name:  "help",
+			args:  "command",
+			short: "show the flags of a command",
+			long:  "Help shows the usage and flags of the given command.",
+			run:   runHelp,
+		},
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: gostress command [flags] [args]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "\t%-10s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun \"gostress help command\" for the flags of a command.\n")
+	os.Exit(2)
+}
+
+func runHelp(cwd string, args []string) os.Error {
+	if len(args) != 1 {
+		usage()
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		return os.NewError("unknown command " + args[0])
+	}
+	if cmd.flags != nil {
+		cmd.flags()
+	}
+	fmt.Fprintf(os.Stderr, "usage: gostress %s %s\n\n%s\n\n", cmd.name, cmd.args, cmd.long)
+	flag.PrintDefaults()
+	return nil
+}
+
+func runPrepare(cwd string, args []string) os.Error {
+	tcs, err := toolchains()
+	if err != nil {
+		return err
+	}
+	for _, tc := range tcs {
+		useToolchain(tc)
+		err = prepareWorkspace(cwd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runList(cwd string, args []string) os.Error {
+	pkgDirs := findPackageDirs()
+	testMains, err := parseTestMains(pkgDirs)
+	if err != nil {
+		return err
+	}
+	blackList := loadBlackList()
+	for _, testMain := range testMains {
+		for _, kind := range []string{TEST, BENCHMARK, EXAMPLE} {
+			var names []string
+			switch kind {
+			case TEST:
+				names = testMain.tests
+			case BENCHMARK:
+				names = testMain.benchmarks
+			case EXAMPLE:
+				names = testMain.examples
+			}
+			for _, name := range names {
+				fullName := testMain.pkgName + "." + name
+				note := ""
+				if listContains(blackList, fullName) || listContains(blackList, testMain.pkgName) {
+					note = " (blacklisted)"
+				}
+				fmt.Printf("%-10s %s%s\n", kind, fullName, note)
+			}
+		}
+	}
+	return nil
+}
+
+func runRunner(cwd string, args []string) os.Error {
+	testMains, err := discoverTests(cwd)
+	if err != nil {
+		return err
+	}
+	return generateRunner("go.go", testMains)
+}
+
+// runSurvey surveys every toolchain in turn, each with its own checkpoint
+// and report, and compares the first toolchain with each of the others.
+func runSurvey(cwd string, args []string) os.Error {
+	tcs, err := toolchains()
+	if err != nil {
+		return err
+	}
+	for _, tc := range tcs {
+		useToolchain(tc)
+		testMains, err := discoverTests(cwd)
+		if err != nil {
+			return err
+		}
+		err = generateSurvey(testMains)
+		if err != nil {
+			return err
+		}
+		err = generateReport(tc.reportDir())
+		if err != nil {
+			return err
+		}
+		if len(tcs) > 1 {
+			err = removeHarnessFiles()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, tc := range tcs[1:] {
+		err = compareToolchains(tcs[0], tc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runReport(cwd string, args []string) os.Error {
+	return generateReport(currentToolchain.reportDir())
+}
+
+func runBench(cwd string, args []string) os.Error {
+	tcs, err := toolchains()
+	if err != nil {
+		return err
+	}
+	if len(tcs) == 1 {
+		testMains, err := discoverTests(cwd)
+		if err != nil {
+			return err
+		}
+		return generateBenchReport(testMains)
+	}
+
+	fmt.Printf("BENCH START\n")
+	samples := make([]map[string]benchSamples, 0, len(tcs))
+	for _, tc := range tcs {
+		useToolchain(tc)
+		fmt.Printf("toolchain %s (%s)\n", tc.name, tc.goroot)
+		testMains, err := discoverTests(cwd)
+		if err != nil {
+			return err
+		}
+		samples = append(samples, collectBench(testMains))
+	}
+	compareToolchainBench(tcs, samples)
+	fmt.Printf("BENCH DONE\n")
+	return nil
+}
+
+// harnessPackage returns the test a harness written by an earlier survey
+// runs and its package, taken from the "// pkg.Name" line the harness
+// starts with.
+func harnessPackage(harness string) (string, string, os.Error) {
+	line, err := readFirstLine(harness)
+	if err != nil {
+		return "", "", err
+	}
+	fullName := strings.TrimSpace(strings.TrimLeft(line, "/"))
+	dot := strings.LastIndex(fullName, ".")
+	if dot < 0 {
+		return "", "", os.NewError(harness + " is not a gostress harness")
+	}
+	return fullName, fullName[:dot], nil
+}
+
+// runReplay runs harnesses written by an earlier survey again.
+func runReplay(cwd string, args []string) os.Error {
+	if len(args) == 0 {
+		return os.NewError("no harness given")
+	}
+	for _, harness := range args {
+		fullName, pkgName, err := harnessPackage(harness)
+		if err != nil {
+			return err
+		}
+		failures := 0
+		for i := 0; i < reruns; i++ {
+			fmt.Printf("%s", fullName)
+			err = executeHarness(harness, pkgName)
+			if err != nil {
+				fmt.Printf(", failed\n")
+				failures++
+			} else {
+				fmt.Printf(", passed\n")
+			}
+		}
+		fmt.Printf("%s: %d of %d runs failed\n", fullName, failures, reruns)
+	}
+	return nil
+}
+
+func runBisect(cwd string, args []string) os.Error {
+	if len(args) != 1 {
+		return os.NewError("bisect takes one harness")
+	}
+	return bisect(cwd, args[0])
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+type runCounts struct {
+	runs, failures int
+}
+
+func (rc *runCounts) rate() float64 {
+	if rc.runs == 0 {
+		return 0
+	}
+	return float64(rc.failures) / float64(rc.runs)
+}
+
+// loadRunCounts counts the runs and failures of every test, and of every
+// condition it ran under, in a survey checkpoint.
+func loadRunCounts(filename string) (map[string]*runCounts, os.Error) {
+	file, err := os.Open(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	counts := make(map[string]*runCounts)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		fields := strings.Split(strings.TrimSpace(line), "\t", -1)
+		if len(fields) < 3 {
+			continue
+		}
+		name := fields[0]
+		if len(fields) > 3 && fields[3] != "default" {
+			name += " [" + fields[3] + "]"
+		}
+		rc := counts[name]
+		if rc == nil {
+			rc = new(runCounts)
+			counts[name] = rc
+		}
+		switch fields[2] {
+		case "passed":
+			rc.runs++
+		case "failed", "resource-limit":
+			rc.runs++
+			rc.failures++
+		}
+	}
+	return counts, nil
+}
+
+// compareToolchains writes the differential report of the surveys of a
+// and b to compare.txt and prints it: the tests that only fail on one of
+// them, and the failure rate deltas with the p-value of Fisher's exact
+// test.
+func compareToolchains(a, b *toolchain) os.Error {
+	countsA, err := loadRunCounts(a.checkpointFile())
+	if err != nil {
+		return err
+	}
+	countsB, err := loadRunCounts(b.checkpointFile())
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0)
+	for name, rc := range countsA {
+		if rc.runs > 0 && countsB[name] != nil && countsB[name].runs > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.SortStrings(names)
+
+	out := bytes.NewBufferString("")
+	section := func(title string, include func(rcA, rcB *runCounts) bool) {
+		fmt.Fprintf(out, "%s\n", title)
+		for _, name := range names {
+			rcA, rcB := countsA[name], countsB[name]
+			if include(rcA, rcB) {
+				p := fisherExact(rcA.failures, rcA.runs, rcB.failures, rcB.runs)
+				fmt.Fprintf(out, "\t%-60s %s %d/%d  %s %d/%d  delta %+6.1f%%  p=%.3f\n",
+					name, a.name, rcA.failures, rcA.runs, b.name, rcB.failures, rcB.runs,
+					100*(rcB.rate()-rcA.rate()), p)
+			}
+		}
+		fmt.Fprintf(out, "\n")
+	}
+	section(fmt.Sprintf("STABLE ON %s, FLAKY ON %s", a.name, b.name), func(rcA, rcB *runCounts) bool {
+		return rcA.failures == 0 && rcB.failures > 0
+	})
+	section(fmt.Sprintf("STABLE ON %s, FLAKY ON %s", b.name, a.name), func(rcA, rcB *runCounts) bool {
+		return rcB.failures == 0 && rcA.failures > 0
+	})
+	section("FAILURE RATE DELTAS", func(rcA, rcB *runCounts) bool {
+		return rcA.failures > 0 || rcB.failures > 0
+	})
+
+	fmt.Print(out.String())
+	return ioutil.WriteFile("compare.txt", out.Bytes(), 0664)
+}
+
+// removeHarnessFiles removes the harnesses and outputs of a survey once
+// its report has been written, before the next toolchain's survey
+// writes its own under the same names.
+func removeHarnessFiles() os.Error {
+	files, err := ioutil.ReadDir(".")
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !f.IsDirectory() && len(f.Name) > 5 && f.Name[1:5] == "Test" {
+			os.Remove(f.Name)
+		}
+	}
+	return nil
+}
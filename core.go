@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"debug/gosym"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+var coreDumps bool
+
+// harnessStart is when the last harness was started, in nanoseconds; a
+// core older than that was left by an earlier run.
+var harnessStart int64
+
+// thread is the state of one thread of a core, from its NT_PRSTATUS note.
+type thread struct {
+	pid    int
+	signal int
+	pc, sp uint64
+}
+
+const ntPrstatus = 1
+
+// offsets into the amd64 struct elf_prstatus: pr_cursig follows the
+// 12 byte pr_info, pr_pid follows the two signal sets, and the registers
+// start at 112 in the order of struct user_regs_struct, where rip is the
+// 17th and rsp the 20th.
+const (
+	prstatusCursig = 12
+	prstatusPid    = 32
+	prstatusRip    = 112 + 16*8
+	prstatusRsp    = 112 + 19*8
+)
+
+// findCore returns the newest core file in runDir written since the run
+// started at since. Where the core ends up depends on
+// /proc/sys/kernel/core_pattern; the default pattern writes "core" or
+// "core.<pid>" to the working directory.
+func findCore(runDir string, since int64) (string, bool) {
+	files, err := ioutil.ReadDir(runDir)
+	if err != nil {
+		return "", false
+	}
+	var newest *os.FileInfo
+	for _, f := range files {
+		if f.IsRegular() && (f.Name == "core" || strings.HasPrefix(f.Name, "core.")) && f.Mtime_ns >= since {
+			if newest == nil || f.Mtime_ns > newest.Mtime_ns {
+				newest = f
+			}
+		}
+	}
+	if newest == nil {
+		return "", false
+	}
+	return path.Join(runDir, newest.Name), true
+}
+
+// coreThreads reads the threads of an amd64 core.
+func coreThreads(core *elf.File) ([]thread, os.Error) {
+	if core.Type != elf.ET_CORE || core.Machine != elf.EM_X86_64 {
+		return nil, os.NewError("not an amd64 core")
+	}
+	threads := make([]thread, 0)
+	order := core.ByteOrder
+	for _, prog := range core.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+		notes := make([]byte, prog.Filesz)
+		_, err := prog.ReadAt(notes, 0)
+		if err != nil {
+			return nil, err
+		}
+		for len(notes) >= 12 {
+			namesz := int(order.Uint32(notes[0:]))
+			descsz := int(order.Uint32(notes[4:]))
+			noteType := order.Uint32(notes[8:])
+			descStart := 12 + (namesz+3)&^3
+			descEnd := descStart + descsz
+			if descEnd > len(notes) {
+				break
+			}
+			desc := notes[descStart:descEnd]
+			if noteType == ntPrstatus && len(desc) >= prstatusRsp+8 {
+				threads = append(threads, thread{
+					pid:    int(order.Uint32(desc[prstatusPid:])),
+					signal: int(order.Uint16(desc[prstatusCursig:])),
+					pc:     order.Uint64(desc[prstatusRip:]),
+					sp:     order.Uint64(desc[prstatusRsp:]),
+				})
+			}
+			next := descStart + (descsz+3)&^3
+			if next > len(notes) {
+				break
+			}
+			notes = notes[next:]
+		}
+	}
+	return threads, nil
+}
+
+// symbolTable reads the Go symbol and line tables of binary.
+func symbolTable(binary string) (*gosym.Table, os.Error) {
+	f, err := elf.Open(binary)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	text := f.Section(".text")
+	symtab := f.Section(".gosymtab")
+	pclntab := f.Section(".gopclntab")
+	if text == nil || symtab == nil || pclntab == nil {
+		return nil, os.NewError(binary + " has no Go symbol table")
+	}
+	symdata, err := symtab.Data()
+	if err != nil {
+		return nil, err
+	}
+	pclndata, err := pclntab.Data()
+	if err != nil {
+		return nil, err
+	}
+	return gosym.NewTable(symdata, gosym.NewLineTable(pclndata, text.Addr))
+}
+
+func symbolize(table *gosym.Table, pc uint64) string {
+	if table == nil {
+		return "?"
+	}
+	file, line, fn := table.PCToLine(pc)
+	if fn == nil {
+		return "?"
+	}
+	return fmt.Sprintf("%s (%s:%d)", fn.Name, file, line)
+}
+
+// goroutineList picks the goroutine headers out of the traceback a
+// crashing harness wrote to stderr, each with the function it was in.
+func goroutineList(stderrFile string) []string {
+	file, err := os.Open(stderrFile, os.O_RDONLY, 0)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	list := make([]string, 0)
+	reader := bufio.NewReader(file)
+	header := ""
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "goroutine ") && strings.HasSuffix(line, ":"):
+			header = line
+		case header != "" && line != "":
+			list = append(list, header+" "+line)
+			header = ""
+		}
+		if err != nil {
+			break
+		}
+	}
+	return list
+}
+
+// summarizeCore describes a core of binary: every thread with the signal
+// it got and its PC and SP, the PC symbolized, followed by the goroutine
+// list from the harness's stderr.
+func summarizeCore(corePath string, binary string, stderrFile string) string {
+	out := bytes.NewBufferString("")
+	fmt.Fprintf(out, "core %s of %s\n\n", path.Base(corePath), path.Base(binary))
+
+	core, err := elf.Open(corePath)
+	if err != nil {
+		fmt.Fprintf(out, "cannot read core: %s\n", err)
+	} else {
+		threads, err := coreThreads(core)
+		core.Close()
+		if err != nil {
+			fmt.Fprintf(out, "cannot read threads: %s\n", err)
+		}
+		table, err := symbolTable(binary)
+		if err != nil {
+			fmt.Fprintf(out, "cannot symbolize: %s\n", err)
+		}
+		for _, t := range threads {
+			fault := ""
+			if t.signal != 0 {
+				fault = fmt.Sprintf(" signal %d", t.signal)
+			}
+			fmt.Fprintf(out, "thread %d%s\n\tpc %#x %s\n\tsp %#x\n", t.pid, fault, t.pc, symbolize(table, t.pc), t.sp)
+		}
+	}
+
+	goroutines := goroutineList(stderrFile)
+	if len(goroutines) > 0 {
+		fmt.Fprintf(out, "\ngoroutines\n")
+		for _, g := range goroutines {
+			fmt.Fprintf(out, "\t%s\n", g)
+		}
+	}
+	return out.String()
+}
+
+// collectCore moves the core a failed harness left in runDir into its
+// artifact directory dir, next to the binary, and writes core.txt with
+// its summary.
+func collectCore(runDir string, dir string, stderrFile string) {
+	if !coreDumps || harnessStart == 0 {
+		return
+	}
+	corePath, ok := findCore(runDir, harnessStart)
+	if !ok {
+		return
+	}
+	dest := path.Join(dir, "core")
+	if os.Rename(corePath, dest) != nil {
+		if err := copyFile(dest, corePath); err != nil {
+			fmt.Fprintf(os.Stderr, "collecting core %s: %s\n", corePath, err)
+			return
+		}
+		os.Remove(corePath)
+	}
+	summary := summarizeCore(dest, path.Join(dir, "test"), stderrFile)
+	ioutil.WriteFile(path.Join(dir, "core.txt"), []byte(summary), 0664)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// findExamples returns the ExampleXxx functions declared in the in-package
+// test files of pkgDir, together with the text of their // Output: comment.
+// Examples without an Output comment are compiled but never run by the
+// testing package, so they are left out here as well.
+func findExamples(pkgDir string, pkgName string) ([]string, map[string]string, os.Error) {
+	examples := make([]string, 0)
+	outputs := make(map[string]string)
+
+	files, err := ioutil.ReadDir(pkgDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range files {
+		if !f.IsRegular() || !strings.HasSuffix(f.Name, "_test.go") {
+			continue
+		}
+		fileNode, err := parser.ParseFile(token.NewFileSet(), path.Join(pkgDir, f.Name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, nil, err
+		}
+		if fileNode.Name.Name != pkgName {
+			continue
+		}
+		for _, decl := range fileNode.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok || funcDecl.Recv != nil || funcDecl.Body == nil || !strings.HasPrefix(funcDecl.Name.Name, "Example") {
+				continue
+			}
+			if len(funcDecl.Type.Params.List) != 0 || funcDecl.Type.Results != nil {
+				continue
+			}
+			output, ok := exampleOutput(fileNode, funcDecl)
+			if !ok {
+				continue
+			}
+			examples = append(examples, funcDecl.Name.Name)
+			outputs[funcDecl.Name.Name] = output
+		}
+	}
+	return examples, outputs, nil
+}
+
+func exampleOutput(fileNode *ast.File, funcDecl *ast.FuncDecl) (string, bool) {
+	for _, group := range fileNode.Comments {
+		if group.Pos() < funcDecl.Body.Lbrace || group.Pos() > funcDecl.Body.Rbrace {
+			continue
+		}
+		lines := make([]string, 0)
+		for _, c := range group.List {
+			text := string(c.Text)
+			text = strings.TrimLeft(text, "/")
+			lines = append(lines, strings.TrimSpace(text))
+		}
+		if len(lines) == 0 || !strings.HasPrefix(lines[0], "Output:") {
+			continue
+		}
+		lines[0] = strings.TrimSpace(lines[0][len("Output:"):])
+		return strings.TrimSpace(strings.Join(lines, "\n")), true
+	}
+	return "", false
+}
+
+func writeExampleTest(testMain *TestMain, example string, goroutines int, filename string) os.Error {
+	src := bytes.NewBufferString("")
+
+	fmt.Fprint(src, "// "+testMain.pkgName+"."+example+"\n")
+
+	fmt.Fprint(src, "//\n")
+	// the expected output travels with the harness, so that replay and
+	// bisect can check it as well
+	fmt.Fprintf(src, "// %s %d\n", exampleMarker, goroutines)
+	for _, line := range outputLines(testMain.exampleOutputs[example]) {
+		fmt.Fprintf(src, "// %s %s\n", outputMarker, line)
+	}
+	fmt.Fprint(src, "package main\n\n")
+	fmt.Fprint(src, "import \"sync\"\n")
+	fmt.Fprintf(src, "import %s \"%s\"\n", testMain.underscorePkgName(), testMain.pkgName)
+	fmt.Fprint(src, "\nfunc main() {\n")
+	fmt.Fprint(src, "wg := new(sync.WaitGroup)\n")
+	fmt.Fprintf(src, "for i := 0; i < %d; i++ {\n", goroutines)
+	fmt.Fprint(src, "wg.Add(1)\n")
+	fmt.Fprint(src, "go func() {\n")
+	fmt.Fprintf(src, "%s.%s()\n", testMain.underscorePkgName(), example)
+	fmt.Fprint(src, "wg.Done()\n")
+	fmt.Fprint(src, "}()\n")
+	fmt.Fprint(src, "}\n\n")
+	fmt.Fprint(src, "wg.Wait()\n")
+	fmt.Fprint(src, "}\n")
+
+	file, err := os.Open(filename, os.O_CREAT|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileset := token.NewFileSet()
+
+	fileNode, err := parser.ParseFile(fileset, filename, src.Bytes(), parser.ParseComments)
+	if err != nil {
+		panic(err)
+	}
+
+	config := printer.Config{printer.TabIndent, 8}
+	_, err = config.Fprint(file, fileset, fileNode)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+const (
+	exampleMarker = "GOSTRESS EXAMPLE"
+	outputMarker  = "GOSTRESS OUTPUT:"
+)
+
+// harnessExampleOutput returns the expected output and the number of
+// goroutines of an example harness written by writeExampleTest. ok is
+// false for other harnesses.
+func harnessExampleOutput(harness string) (want string, goroutines int, ok bool) {
+	data, err := ioutil.ReadFile(harness)
+	if err != nil {
+		return "", 0, false
+	}
+	lines := make([]string, 0)
+	for _, line := range strings.Split(string(data), "\n", -1) {
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+		line = strings.TrimSpace(line[2:])
+		switch {
+		case strings.HasPrefix(line, exampleMarker):
+			goroutines, err = strconv.Atoi(strings.TrimSpace(line[len(exampleMarker):]))
+			ok = err == nil
+		case strings.HasPrefix(line, outputMarker):
+			lines = append(lines, strings.TrimSpace(line[len(outputMarker):]))
+		}
+	}
+	return strings.Join(lines, "\n"), goroutines, ok
+}
+
+// executeHarness runs a harness, checking the output of an example
+// harness against the output it expects.
+func executeHarness(test string, pkgName string) os.Error {
+	if want, goroutines, ok := harnessExampleOutput(test); ok {
+		return executeExampleTest(test, pkgName, want, goroutines)
+	}
+	return executeSingleTest(test, pkgName, nil, nil)
+}
+
+func outputLines(output string) []string {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(output, "\n", -1) {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// checkExampleOutput compares what the harness printed with the expected
+// output of every goroutine. All goroutines share os.Stdout, so their
+// output cannot be told apart; instead the printed lines must be exactly
+// the expected lines repeated once per goroutine, in any order. A torn,
+// lost or duplicated write shows up as a difference.
+func checkExampleOutput(stdoutFile string, want string, goroutines int) (string, os.Error) {
+	data, err := ioutil.ReadFile(stdoutFile)
+	if err != nil {
+		return "", err
+	}
+	got := outputLines(string(data))
+	expected := make([]string, 0)
+	for i := 0; i < goroutines; i++ {
+		expected = append(expected, outputLines(want)...)
+	}
+	sort.SortStrings(got)
+	sort.SortStrings(expected)
+
+	diff := bytes.NewBufferString("")
+	i, j := 0, 0
+	for i < len(got) || j < len(expected) {
+		switch {
+		case j == len(expected) || (i < len(got) && got[i] < expected[j]):
+			fmt.Fprintf(diff, "unexpected: %s\n", got[i])
+			i++
+		case i == len(got) || got[i] > expected[j]:
+			fmt.Fprintf(diff, "missing: %s\n", expected[j])
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return diff.String(), nil
+}
+
+// executeExampleTest runs the example harness like any other and checks
+// its output. A mismatch is added to the .output file, so that the report
+// picks it up as a failure, and gets an artifact bundle like any other;
+// the .stdout file is kept with it, as for any failed run.
+func executeExampleTest(test string, pkgName string, want string, goroutines int) os.Error {
+	return executeSingleTest(test, pkgName, nil, func() (string, os.Error) {
+		return checkExampleOutput(test+".stdout", want, goroutines)
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+)
+
+var (
+	ciLevel   float64
+	adaptive  bool
+	ciWidth   float64
+	maxReruns int
+)
+
+func ciFlags() {
+	flag.Float64Var(&ciLevel, "cilevel", 0.95, "confidence level of the failure rate intervals")
+}
+
+func adaptiveFlags() {
+	flag.BoolVar(&adaptive, "adaptive", false, "keep rerunning each test until its failure rate interval is narrower than -ciwidth")
+	flag.Float64Var(&ciWidth, "ciwidth", 0.05, "width of the failure rate interval -adaptive stops at")
+	flag.IntVar(&maxReruns, "maxreruns", 1000, "most runs of one test with -adaptive")
+}
+
+// formatRate formats a failure rate with its Wilson interval.
+func formatRate(failures, runs int) string {
+	lo, hi := wilson(failures, runs, ciLevel)
+	return fmt.Sprintf("%d/%d %.2f%% [%.2f%%, %.2f%%]", failures, runs,
+		100*float64(failures)/float64(runs), 100*lo, 100*hi)
+}
+
+// needsMoreRuns tells whether a test that has had nthTime runs so far
+// should run again. Without -adaptive every test runs runsPerTest times;
+// with it a test runs on until the interval of its failure rate is
+// narrow enough or -maxreruns is reached. Tests that were skipped every
+// time have no rate to narrow down. With -budget a test runs as often as
+// the plan says, as long as there is time left.
+func (state *surveyState) needsMoreRuns(fullName string, nthTime int) bool {
+	if state.budget > 0 {
+		return nthTime < budgetMinRuns() || (nthTime < state.plannedRuns(fullName) && state.withinBudget())
+	}
+	if nthTime < runsPerTest() {
+		return true
+	}
+	if !adaptive || nthTime >= maxReruns {
+		return false
+	}
+	rc := state.counts[fullName]
+	if rc == nil || rc.runs == 0 {
+		return false
+	}
+	lo, hi := wilson(rc.failures, rc.runs, ciLevel)
+	return hi-lo > ciWidth
+}
+
+func (state *surveyState) count(fullName string, result string) {
+	rc := state.counts[fullName]
+	if rc == nil {
+		rc = new(runCounts)
+		state.counts[fullName] = rc
+	}
+	switch result {
+	case "passed":
+		rc.runs++
+	case "failed", "resource-limit":
+		rc.runs++
+		rc.failures++
+	}
+}
+
+// printFailureRates lists the tests that failed at least once with the
+// interval of their failure rate, so that a 1 in 10 failure can be told
+// apart from a 1 in 1000 one.
+func (state *surveyState) printFailureRates() {
+	names := make([]string, 0)
+	for name, rc := range state.counts {
+		if rc.failures > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.SortStrings(names)
+	fmt.Printf("FAILURE RATES (%.0f%% intervals)\n", 100*ciLevel)
+	for _, name := range names {
+		rc := state.counts[name]
+		fmt.Printf("\t%-60s %s\n", name, formatRate(rc.failures, rc.runs))
+	}
+}
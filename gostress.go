@@ -138,7 +138,7 @@ func copyTestPackages(testRoot string, pkgDirs []string) os.Error {
 		}
 		for _, pkg := range findPackages(path.Join(pkgDir, "_test")) {
 			newPkg := path.Join(testPkgDir, path.Base(pkg))
-			err = copyFile(newPkg, pkg)
+			err = syncFile(newPkg, pkg)
 			if err != nil {
 				return err
 			}
@@ -150,6 +150,8 @@ func copyTestPackages(testRoot string, pkgDirs []string) os.Error {
 type TestMain struct {
 	pkgName           string
 	tests, benchmarks []string
+	examples          []string
+	exampleOutputs    map[string]string
 }
 
 func (tm *TestMain) underscorePkgName() string {
@@ -203,15 +205,19 @@ func parseTestMains(pkgDirs []string) ([]*TestMain, os.Error) {
 				}
 			}
 		}
-		if len(tests) == 0 && len(benchmarks) == 0 {
+		examples, exampleOutputs, err := findExamples(pkgDir, pkgParts[len(pkgParts)-1])
+		if err != nil {
+			return nil, err
+		}
+		if len(tests) == 0 && len(benchmarks) == 0 && len(examples) == 0 {
 			continue
 		}
-		testMains = append(testMains, &TestMain{pkgName, tests, benchmarks})
+		testMains = append(testMains, &TestMain{pkgName, tests, benchmarks, examples, exampleOutputs})
 	}
-	return testMains, nil
+	return selectTestMains(testMains)
 }
 
-func writeSingleTest(testMain *TestMain, testName string, testType int, filename string) os.Error {
+func writeSingleTest(testMain *TestMain, testName string, testType int, goroutines int, filename string) os.Error {
 
 	src := bytes.NewBufferString("")
 
@@ -224,12 +230,21 @@ func writeSingleTest(testMain *TestMain, testName string, testType int, filename
 	if testMain.pkgName != "regexp" {
 		fmt.Fprint(src, "import \"regexp\"\n")
 	}
+	if testType == 1 && testMain.pkgName != "flag" {
+		fmt.Fprint(src, "import \"flag\"\n")
+	}
 	fmt.Fprintf(src, "import %s \"%s\"\n", testMain.underscorePkgName(), testMain.pkgName)
 	fmt.Fprint(src, "\nfunc main() {\n")
 	fmt.Fprint(src, "wg := new(sync.WaitGroup)\n")
 	pkgName := testMain.underscorePkgName()
+	if testType == 1 {
+		// RunBenchmarks runs nothing unless -benchmarks is set; a
+		// -benchmarks argument (-testbenchmarks) overrides this default
+		fmt.Fprintf(src, "flag.Set(\"benchmarks\", \"^%s$\")\n", testMain.pkgName+"."+testName)
+		fmt.Fprint(src, "flag.Parse()\n")
+	}
 
-	fmt.Fprintf(src, "for i := 0; i < %d; i++ {\n", iters)
+	fmt.Fprintf(src, "for i := 0; i < %d; i++ {\n", goroutines)
 
 	fmt.Fprint(src, "wg.Add(1)\n")
 	fmt.Fprint(src, "go func() {\n")
@@ -279,15 +294,10 @@ func writeSingleTest(testMain *TestMain, testName string, testType int, filename
 }
 
 
-func executeSingleTest(test string) os.Error {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return err
-	}
+func toolchainTools() (compiler, linker string) {
 	origEnv := os.Getenv("GOROOT")
 
 	//Set up compiler and linker location
-	var compiler, linker string
 	var compilerExec, linkerExec string
 
 	gobinArch := os.Getenv("GOARCH")
@@ -308,13 +318,30 @@ func executeSingleTest(test string) os.Error {
 		compiler = origEnv + "/bin/" + compilerExec
 		linker = origEnv + "/bin/" + linkerExec
 	}
+	return compiler, linker
+}
 
-	myProcess, err := os.StartProcess(compiler, []string{"", "-e", "-o", test + ".6", test}, []string{"GOROOT=" + cwd + "/go.gostress", "GOMAXPROCS=" + strconv.Itoa(gomaxproc)}, ".", nil)
+// executeSingleTest compiles, links and runs a harness. The harness
+// writes to stdout if given; check, if given, is called after a run that
+// exited normally and returns what was wrong with its output, which then
+// fails the run.
+func executeSingleTest(test string, pkgName string, stdout *os.File, check func() (string, os.Error)) os.Error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	compiler, linker := currentToolchain.tools()
+	workspace := currentToolchain.workspace(cwd)
+
+	myProcess, err := os.StartProcess(compiler, []string{"", "-e", "-o", test + ".6", test}, []string{"GOROOT=" + workspace, "GOMAXPROCS=" + strconv.Itoa(gomaxproc)}, ".", nil)
 
 	if err != nil {
 		return err
 	}
+	trackChild(myProcess.Pid, false)
 	waitMsg, err := myProcess.Wait(0)
+	untrackChild(myProcess.Pid)
 	if err != nil {
 		return err
 	}
@@ -324,13 +351,15 @@ func executeSingleTest(test string) os.Error {
 
 	//fmt.Printf ("\nCompiled\n")
 
-	myProcess, err = os.StartProcess(linker, []string{"", "-o", "work/test", test + ".6"}, []string{"GOROOT=" + cwd + "/go.gostress", "GOMAXPROCS=" + strconv.Itoa(gomaxproc)}, ".", nil)
+	myProcess, err = os.StartProcess(linker, []string{"", "-o", "work/test", test + ".6"}, []string{"GOROOT=" + workspace, "GOMAXPROCS=" + strconv.Itoa(gomaxproc)}, ".", nil)
 
 	//myProcess, err = os.StartProcess("./myTest", []string{"-o test", test + ".6"},nil, ".", []*os.File {os.Stdin, os.Stdout, os.Stderr})
 	if err != nil {
 		return err
 	}
+	trackChild(myProcess.Pid, false)
 	waitMsg, err = myProcess.Wait(0)
+	untrackChild(myProcess.Pid)
 	if err != nil {
 		return err
 	}
@@ -339,68 +368,195 @@ func executeSingleTest(test string) os.Error {
 	}
 	//fmt.Printf ("\nLinked\n")
 
+	runDir := packageRunDir(cwd, pkgName)
+	if sandbox {
+		runDir, err = createSandbox(cwd, test, pkgName)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = os.MkdirAll(runDir, 0764)
+		if err != nil {
+			return err
+		}
+	}
+
 	errLog, err := os.Open(test+".output", os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0666)
 	if err != nil {
 		panic(err)
 	}
 	defer errLog.Close()
+	if currentCondition.name != "default" {
+		errLog.WriteString("GOSTRESS CONDITION: " + currentCondition.name + "\n")
+	}
+	ownStdout := stdout == nil
+	if ownStdout {
+		stdout, err = os.Open(test+".stdout", os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0666)
+		if err != nil {
+			return err
+		}
+		defer stdout.Close()
+	}
+	var outputCapture *capture
+	if interleave {
+		outputCapture, err = startCapture(test, stdout, errLog)
+		if err != nil {
+			return err
+		}
+	}
+	env := harnessEnv(cwd, runDir)
+	failed := func(err os.Error) os.Error {
+		saveArtifacts(cwd, test, runDir, env, stdout)
+		return err
+	}
 
 	var procResp *os.Process
-	response := make(chan bool)
+	response := make(chan os.Error)
 	processChan := make(chan *os.Process)
 	if timeout > 0 {
 		ticker := time.NewTicker(timeout * 1000000000)
-		var boolResp bool
-		go pushTest(cwd, response, errLog, processChan)
+		go pushTest(cwd, runDir, env, response, stdout, errLog, outputCapture, processChan)
 		procResp = <-processChan
 		select {
-		case boolResp = <-response:
-			if boolResp == false {
-				return os.NewError("Test case did not return normal")
+		case err = <-response:
+			if err != nil {
+				return failed(err)
 			}
 		case <-ticker.C:
 			errLog.WriteString("GOSTRESS TIMEOUT!!!\n")
 			syscall.Kill(procResp.Pid, syscall.SIGQUIT)
-			return os.NewError("Test case timeout")
+			// give the harness time to write its goroutine dump
+			select {
+			case <-response:
+			case <-time.After(10e9):
+				syscall.Kill(procResp.Pid, syscall.SIGKILL)
+				<-response
+			}
+			return failed(os.NewError("Test case timeout"))
 		}
 	} else {
-		go pushTest(cwd, response, errLog, processChan)
+		go pushTest(cwd, runDir, env, response, stdout, errLog, outputCapture, processChan)
 		procResp = <-processChan
 		select {
-		case boolResp := <-response:
-			if boolResp == false {
-				return os.NewError("Test case did not return normal")
+		case err = <-response:
+			if err != nil {
+				return failed(err)
 			}
 		}
 	}
 
 	//process went smoothly
 
+	if check != nil {
+		problem, err := check()
+		if err != nil {
+			return failed(err)
+		}
+		if problem != "" {
+			errLog.WriteString(problem)
+			return failed(os.NewError("Harness output did not match"))
+		}
+	}
+
 	err = os.Remove(test + ".output")
 	if err != nil {
 		panic(err)
 	}
+	if ownStdout && !keepOutput {
+		os.Remove(test + ".stdout")
+	}
+	if interleave && !keepOutput {
+		os.Remove(test + ".log")
+	}
+	if sandbox {
+		err = os.RemoveAll(runDir)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func pushTest(cwd string, response chan bool, errLog *os.File, processChan chan *os.Process) {
-	myProcess, err := os.StartProcess("test", []string{"test"}, []string{"PATH=" + os.Getenv("PATH"), "GOROOT=" + cwd + "/go.gostress", "GOMAXPROCS=" + strconv.Itoa(gomaxproc)}, "./work", []*os.File{os.Stdin, nil, errLog})
-	if err != nil {
+// harnessEnv is the environment a harness runs with.
+func harnessEnv(cwd string, runDir string) []string {
+	env := []string{"PATH=" + os.Getenv("PATH"), "GOROOT=" + currentToolchain.workspace(cwd), "GOMAXPROCS=" + strconv.Itoa(gomaxproc)}
+	env = append(env, stressors...)
+	env = append(env, currentCondition.env...)
+	if sandbox {
+		env = append(env, sandboxEnv(runDir)...)
+	}
+	return env
+}
+
+func pushTest(cwd string, runDir string, env []string, response chan os.Error, stdout *os.File, errLog *os.File, outputCapture *capture, processChan chan *os.Process) {
+	harnessArgv, harnessStart = nil, 0
+	childOut, childErr := stdout, errLog
+	if outputCapture != nil {
+		childOut, childErr = outputCapture.stdout, outputCapture.stderr
+	}
+	abort := func(err os.Error) {
+		if outputCapture != nil {
+			outputCapture.closeWriters()
+			outputCapture.wait()
+		}
 		processChan <- nil
-		response <- false
+		response <- err
+	}
+	devNull, err := os.Open("/dev/null", os.O_RDONLY, 0)
+	if err != nil {
+		abort(err)
+		return
+	}
+	defer devNull.Close()
+	name, argv, err := harnessCommand(path.Join(cwd, "work", "test"))
+	if err != nil {
+		abort(err)
 		return
 	}
+	name, argv, err = underCondition(currentCondition, name, argv)
+	if err != nil {
+		abort(err)
+		return
+	}
+	name, argv, err = withLimits(name, argv)
+	if err != nil {
+		abort(err)
+		return
+	}
+	name, argv, group := inNewProcessGroup(name, argv)
+	harnessArgv = append([]string{name}, argv[1:]...)
+	start := time.Nanoseconds()
+	harnessStart = start
+	myProcess, err := os.StartProcess(name, argv, env, runDir, []*os.File{devNull, childOut, childErr})
+	if err != nil {
+		abort(err)
+		return
+	}
+	trackChild(myProcess.Pid, group)
 	processChan <- myProcess
+	if outputCapture != nil {
+		outputCapture.closeWriters()
+	}
 	waitMsg, err := myProcess.Wait(0)
+	harnessTime = time.Nanoseconds() - start
+	untrackChild(myProcess.Pid)
+	if outputCapture != nil {
+		outputCapture.wait()
+	}
 	if err != nil {
-		response <- false
+		response <- err
 		return
 	}
-	if waitMsg.ExitStatus() != 0 {
-		response <- false
+	if waitMsg.ExitStatus() != 0 || waitMsg.Signaled() {
+		if limit, hit := resourceLimitHit(waitMsg, errLog.Name()); hit {
+			errLog.WriteString("GOSTRESS RESOURCE LIMIT: " + limit + "\n")
+			response <- errResourceLimit
+			return
+		}
+		response <- os.NewError("Test case did not return normal")
 		return
 	}
-	response <- true
+	response <- nil
 }
 
 
@@ -476,7 +632,10 @@ func writePackageTest(filename string, testMain *TestMain) os.Error {
 
 
 func loadBlackList() []string {
-	file, err := os.Open("blacklist", os.O_RDONLY, 0764)
+	if blacklistFile == "" {
+		return nil
+	}
+	file, err := os.Open(blacklistFile, os.O_RDONLY, 0764)
 	if err != nil {
 		fmt.Printf("Could not find blacklist\n")
 		return []string{""}
@@ -512,7 +671,7 @@ func loadBlackList() []string {
 
 	file.Close()
 
-	file, err = os.Open("blacklist", os.O_RDONLY, 0764)
+	file, err = os.Open(blacklistFile, os.O_RDONLY, 0764)
 	if err != nil {
 		fmt.Printf("Could not open blacklist\n")
 		//panic (err)
@@ -558,10 +717,89 @@ func listContains(list []string, word string) bool {
 const (
 	TEST      string = "TEST"
 	BENCHMARK string = "BENCHMARK"
+	EXAMPLE   string = "EXAMPLE"
 	PACKAGE   string = "PACKAGE"
 )
 
-func runTest(testMain *TestMain, testName string, typeOfTest string, testCount int, blackList []string, nthTime int) bool {
+// surveyState is what every run of a survey shares.
+type surveyState struct {
+	blackList  []string
+	prog       *progress
+	checkpoint *checkpoint
+	// runs and failed runs of every test under every condition
+	conditionRuns map[string]map[string]int
+	failures      map[string]map[string]int
+	// runs and failures of every test over all conditions
+	counts map[string]*runCounts
+	// with -budget, the planned runs of every test
+	budget  int64
+	plan    map[string]int
+	history *os.File
+}
+
+// record finishes a run of fullName with result.
+func (state *surveyState) record(fullName string, nthTime int, result string) {
+	state.prog.end(result)
+	state.checkpoint.record(fullName, nthTime, result)
+	state.count(fullName, result)
+	state.countCondition(fullName, currentCondition.name, result)
+	if state.history != nil && result != "skipped" && harnessTime > 0 {
+		fmt.Fprintf(state.history, "%s\t%s\t%d\n", fullName, result, harnessTime)
+	}
+}
+
+// plannedRuns is how many runs of fullName the survey expects to make.
+func (state *surveyState) plannedRuns(fullName string) int {
+	if state.budget == 0 {
+		return runsPerTest()
+	}
+	if state.plan[fullName] < budgetMinRuns() {
+		return budgetMinRuns()
+	}
+	return state.plan[fullName]
+}
+
+func countIn(counts map[string]map[string]int, condition string, fullName string) {
+	byTest := counts[condition]
+	if byTest == nil {
+		byTest = make(map[string]int)
+		counts[condition] = byTest
+	}
+	byTest[fullName]++
+}
+
+// countCondition counts a run of fullName that ran under condition.
+func (state *surveyState) countCondition(fullName string, condition string, result string) {
+	switch result {
+	case "passed":
+		countIn(state.conditionRuns, condition, fullName)
+	case "failed", "resource-limit":
+		countIn(state.conditionRuns, condition, fullName)
+		countIn(state.failures, condition, fullName)
+	}
+}
+
+// printFailuresByCondition lists, for every condition of the sweep, the
+// tests that failed under it, so the knobs that reproduce a failure can
+// be read off directly.
+func (state *surveyState) printFailuresByCondition() {
+	if len(sweepConditions()) < 2 || len(state.failures) == 0 {
+		return
+	}
+	fmt.Printf("FAILURES BY CONDITION\n")
+	for _, c := range sweepConditions() {
+		byTest := state.failures[c.name]
+		if len(byTest) == 0 {
+			continue
+		}
+		fmt.Printf("%s:\n", c.name)
+		for fullName, count := range byTest {
+			fmt.Printf("\t%s (%d of %d)\n", fullName, count, state.conditionRuns[c.name][fullName])
+		}
+	}
+}
+
+func runTest(testMain *TestMain, testName string, typeOfTest string, testCount int, nthTime int, state *surveyState) bool {
 	var fullName, filename string
 	if typeOfTest == PACKAGE {
 		filename = "pTest" + testMain.underscorePkgName() + "_" + strconv.Itoa(nthTime) + ".go"
@@ -571,18 +809,31 @@ func runTest(testMain *TestMain, testName string, typeOfTest string, testCount i
 		fullName = testMain.pkgName + "." + testName
 	}
 
-	fmt.Printf("%s", fullName)
-	if listContains(blackList, fullName) || listContains(blackList, testMain.pkgName) {
-		fmt.Printf(", skipped\n")
+	if result, condition, ok := state.checkpoint.lookup(fullName, nthTime); ok {
+		state.prog.restore(result)
+		state.count(fullName, result)
+		state.countCondition(fullName, condition, result)
+		return result == "passed" || result == "skipped"
+	}
+	if isInterrupted() {
+		surveyInterrupted(fullName, nthTime, filename, false, state)
+	}
+
+	currentCondition = conditionOfRun(nthTime)
+	state.prog.begin(fullName)
+	if listContains(state.blackList, fullName) || listContains(state.blackList, testMain.pkgName) {
+		state.record(fullName, nthTime, "skipped")
 		return true
 	}
 
 	var err os.Error
 	switch typeOfTest {
 	case TEST:
-		err = writeSingleTest(testMain, testName, 0, filename)
+		err = writeSingleTest(testMain, testName, 0, iters, filename)
 	case BENCHMARK:
-		err = writeSingleTest(testMain, testName, 1, filename)
+		err = writeSingleTest(testMain, testName, 1, iters, filename)
+	case EXAMPLE:
+		err = writeExampleTest(testMain, testName, iters, filename)
 	case PACKAGE:
 		err = writePackageTest(filename, testMain)
 	}
@@ -590,18 +841,48 @@ func runTest(testMain *TestMain, testName string, typeOfTest string, testCount i
 		panic(err)
 	}
 
-	err = executeSingleTest(filename)
-	if err != nil {
+	harnessTime = 0
+	err = executeHarness(filename, testMain.pkgName)
+	if isInterrupted() {
+		surveyInterrupted(fullName, nthTime, filename, true, state)
+	}
+	if err == errResourceLimit {
+		state.record(fullName, nthTime, "resource-limit")
+		return false
+	} else if err != nil {
 		//panic (err)
-		fmt.Printf(", failed\n")
+		state.record(fullName, nthTime, "failed")
 		return false
 	} else {
-		fmt.Printf(", passed\n")
+		state.record(fullName, nthTime, "passed")
 		return true
 	}
 	return false
 }
 
+// surveyInterrupted records the run cut short by SIGINT or SIGTERM, if it
+// had started, drops the output and sandbox the killed harness left
+// behind and exits with a summary. The results of the finished runs are
+// already in the checkpoint.
+func surveyInterrupted(fullName string, nthTime int, filename string, started bool, state *surveyState) {
+	p := state.prog
+	finished := p.done
+	if started {
+		p.end("interrupted")
+		state.checkpoint.record(fullName, nthTime, "interrupted")
+	}
+	state.checkpoint.close()
+	for _, suffix := range []string{".output", ".stdout", ".log"} {
+		os.Remove(filename + suffix)
+	}
+	if cwd, err := os.Getwd(); err == nil {
+		os.RemoveAll(path.Join(cwd, "work", "sandbox", filename))
+	}
+	fmt.Printf("\nSURVEY INTERRUPTED during %s after %d of %d runs: %d passed, %d failed, %d skipped\n", fullName, finished, p.total, p.passed, p.failed, p.skipped)
+	fmt.Printf("continue with: gostress survey -resume\n")
+	os.Exit(130)
+}
+
 func generateSurvey(testMains []*TestMain) os.Error {
 
 	fmt.Printf("SURVEY START\n")
@@ -613,12 +894,43 @@ func generateSurvey(testMains []*TestMain) os.Error {
 		panic(err)
 	}
 
+	checkpoint, err := openCheckpoint(currentToolchain.checkpointFile(), resume)
+	if err != nil {
+		return err
+	}
+	defer checkpoint.close()
+	state := &surveyState{blackList, newProgress(0), checkpoint, make(map[string]map[string]int), make(map[string]map[string]int), make(map[string]*runCounts), 0, nil, nil}
+	if budgetFlag != "" {
+		state.budget, err = parseBudget(budgetFlag)
+		if err != nil {
+			return err
+		}
+		state.plan, err = budgetPlan(testMains, blackList, state.budget)
+		if err != nil {
+			return err
+		}
+	}
+	for _, testMain := range testMains {
+		for _, list := range [][]string{testMain.tests, testMain.benchmarks, testMain.examples, []string{"head"}} {
+			for _, name := range list {
+				state.prog.total += state.plannedRuns(testMain.pkgName + "." + name)
+			}
+		}
+	}
+	state.history, err = os.Open(currentToolchain.historyFile(), os.O_WRONLY|os.O_CREAT|os.O_APPEND, 0664)
+	if err != nil {
+		return err
+	}
+	defer state.history.Close()
+	setSurveyActive(true)
+	defer setSurveyActive(false)
+
 	for _, testMain := range testMains {
 		testCount := 0
 		for _, test := range testMain.tests {
 			failures := 0
-			for i := 0; i < reruns; i++ {
-				result := runTest(testMain, test, TEST, testCount, blackList, i)
+			for i := 0; state.needsMoreRuns(testMain.pkgName+"."+test, i); i++ {
+				result := runTest(testMain, test, TEST, testCount, i, state)
 				if result == false {
 					failures++
 				}
@@ -628,8 +940,8 @@ func generateSurvey(testMains []*TestMain) os.Error {
 		}
 		for _, benchmark := range testMain.benchmarks {
 			failures := 0
-			for i := 0; i < reruns; i++ {
-				result := runTest(testMain, benchmark, BENCHMARK, testCount, blackList, i)
+			for i := 0; state.needsMoreRuns(testMain.pkgName+"."+benchmark, i); i++ {
+				result := runTest(testMain, benchmark, BENCHMARK, testCount, i, state)
 				if result == false {
 					failures++
 				}
@@ -637,9 +949,20 @@ func generateSurvey(testMains []*TestMain) os.Error {
 			testCount = testCount + 1
 			resultFile.WriteString(benchmark + ":" + strconv.Itoa(failures) + "\n")
 		}
+		for _, example := range testMain.examples {
+			failures := 0
+			for i := 0; state.needsMoreRuns(testMain.pkgName+"."+example, i); i++ {
+				result := runTest(testMain, example, EXAMPLE, testCount, i, state)
+				if result == false {
+					failures++
+				}
+			}
+			testCount = testCount + 1
+			resultFile.WriteString(example + ":" + strconv.Itoa(failures) + "\n")
+		}
 		failures := 0
-		for i := 0; i < reruns; i++ {
-			result := runTest(testMain, "", PACKAGE, 0, blackList, i)
+		for i := 0; state.needsMoreRuns(testMain.pkgName+".head", i); i++ {
+			result := runTest(testMain, "", PACKAGE, 0, i, state)
 			if result == false {
 				failures++
 			}
@@ -647,6 +970,9 @@ func generateSurvey(testMains []*TestMain) os.Error {
 		resultFile.WriteString(testMain.pkgName + ":" + strconv.Itoa(failures) + "\n")
 	}
 	resultFile.Close()
+	state.prog.finish()
+	state.printFailuresByCondition()
+	state.printFailureRates()
 	fmt.Printf("SURVEY DONE\n")
 	return nil
 }
@@ -673,15 +999,36 @@ func (arr MapEntryArray) Swap(i, j int) {
 type testRecord struct {
 	name         string
 	failures     int
+	runs         int
 	failureFiles []string
+	// stdout kept by -keepoutput of the runs that passed
+	keptFiles    []string
 	origFileName string
 }
 
 type setTestRecord map[string]testRecord
 
-func generateReport() os.Error {
+// runFile tells whether name is a file of one run of the harness
+// coreFileName, that is coreFileName_<rerun> followed by an extension,
+// and returns the extension. Matching the rerun number keeps pTestio_
+// from picking up the runs of pTestio_ioutil_.
+func runFile(name string, coreFileName string) (string, bool) {
+	if !strings.HasPrefix(name, coreFileName+"_") {
+		return "", false
+	}
+	rest := name[len(coreFileName)+1:]
+	digits := 0
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+	if digits == 0 || !strings.HasPrefix(rest[digits:], ".go") {
+		return "", false
+	}
+	return rest[digits:], true
+}
+
+func generateReport(dirName string) os.Error {
 
-	dirName := "report"
 	os.Mkdir(dirName, 0764)
 	files, err := ioutil.ReadDir(".")
 
@@ -698,9 +1045,11 @@ func generateReport() os.Error {
 			}
 		}
 	}
-	err = copyFile(dirName+"/blacklist", "blacklist")
-	if err != nil {
-		panic(err)
+	if blacklistFile != "" {
+		err = copyFile(dirName+"/blacklist", blacklistFile)
+		if err != nil {
+			panic(err)
+		}
 	}
 	err = copyFile(dirName+"/result.file", "result.file")
 	if err != nil {
@@ -746,8 +1095,27 @@ func generateReport() os.Error {
 			//fmt.Printf ("f.Name: %v\n", f.Name)
 			coreFileName := f.Name[0:strings.LastIndex(f.Name, "_")]
 			record.failures = 0
+			record.runs = 0
+			record.keptFiles = nil
+			for _, runF := range files {
+				if runF.IsDirectory() {
+					continue
+				}
+				ext, ok := runFile(runF.Name, coreFileName)
+				if !ok {
+					continue
+				}
+				if ext == ".go" {
+					record.runs++
+				}
+				if ext == ".go.stdout" {
+					if _, err := os.Stat(dirName + "/" + runF.Name[:len(runF.Name)-len(".stdout")] + ".output"); err != nil {
+						record.keptFiles = append(record.keptFiles, runF.Name)
+					}
+				}
+			}
 			for _, outputF := range files {
-				if !outputF.IsDirectory() && strings.HasSuffix(outputF.Name, ".go.output") && strings.Contains(outputF.Name, coreFileName) {
+				if ext, ok := runFile(outputF.Name, coreFileName); ok && !outputF.IsDirectory() && ext == ".go.output" {
 					record.failures = record.failures + 1
 					var contains bool = false
 					for _, ent := range record.failureFiles {
@@ -824,7 +1192,7 @@ func generateReport() os.Error {
 		pack := entry.key
 		detail := entry.value
 
-		file, err := os.Open("report/"+strings.Replace(pack, "/", "_", -1)+".html", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0764)
+		file, err := os.Open(dirName+"/"+strings.Replace(pack, "/", "_", -1)+".html", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0764)
 		if err != nil {
 			panic(err)
 		}
@@ -855,7 +1223,7 @@ func generateReport() os.Error {
 				file.WriteString("#FF0000")
 				file.WriteString("\" width=\"10\"></td>")
 			}
-			for i := packRecord.failures; i < reruns; i++ {
+			for i := packRecord.failures; i < packRecord.runs; i++ {
 				file.WriteString("<td style=\"background-color: ")
 				file.WriteString("#00FF00")
 				file.WriteString("\" width=\"10\"></td>")
@@ -869,11 +1237,26 @@ func generateReport() os.Error {
 			file.WriteString("\">")
 			file.WriteString(packName)
 			file.WriteString("</a>")
+			if packRecord.runs > 0 {
+				file.WriteString(" " + formatRate(packRecord.failures, packRecord.runs))
+			}
 			//if len(details) == 3 {
 			for i := 0; i < packRecord.failures; i++ {
 				file.WriteString("...<a href=\"")
 				file.WriteString(packRecord.failureFiles[i])
 				file.WriteString("\">output" + strconv.Itoa(i) + "</a>")
+				if cond := conditionOfOutput(dirName + "/" + packRecord.failureFiles[i]); cond != "" {
+					file.WriteString(" (" + cond + ")")
+				}
+				for _, stream := range []string{"stdout", "log"} {
+					other := strings.Replace(packRecord.failureFiles[i], ".go.output", ".go."+stream, 1)
+					if _, err := os.Stat(dirName + "/" + other); err == nil {
+						file.WriteString(" <a href=\"" + other + "\">" + stream + "</a>")
+					}
+				}
+			}
+			for i, kept := range packRecord.keptFiles {
+				file.WriteString("...<a href=\"" + kept + "\">stdout" + strconv.Itoa(i) + "</a>")
 			}
 			file.WriteString("</td></tr>\n")
 		}
@@ -976,75 +1359,157 @@ func generateRunner(filename string, testMains []*TestMain) os.Error {
 	return nil
 }
 
-func main() {
-	cwd, err := os.Getwd()
-	if err != nil {
-		panic(err)
-	}
-	testRoot := path.Join(cwd, "go.gostress")
+// discoverTests copies the test builds of all packages into the stress
+// workspace and returns the tests, benchmarks and examples found in them.
+func discoverTests(cwd string) ([]*TestMain, os.Error) {
+	testRoot := currentToolchain.workspace(cwd)
 	if GOROOT == testRoot {
 		panic("Test would overwrite GOROOT")
 	}
+	err := checkWorkspace(cwd)
+	if err != nil {
+		return nil, err
+	}
 
 	pkgDirs := findPackageDirs()
 
 	err = copyTestPackages(testRoot, pkgDirs)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	testMains, err := parseTestMains(pkgDirs)
-	if err != nil {
-		panic(err)
+	return parseTestMains(pkgDirs)
+}
+
+func main() {
+	go handleSignals()
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd := findCommand(os.Args[1])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "gostress: unknown command %q\n", os.Args[1])
+		usage()
 	}
 
-	if mode == RUNNER {
-		err = generateRunner("go.go", testMains)
-		if err != nil {
-			panic(err)
-		}
-	} else if mode == SURVEY {
-		err = generateSurvey(testMains)
+	// the flag package only knows about os.Args, so drop the command name
+	// and let the command register its own flags before parsing
+	os.Args = append([]string{os.Args[0]}, os.Args[2:]...)
+	if cmd.flags != nil {
+		cmd.flags()
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gostress %s %s\n\n%s\n\n", cmd.name, cmd.args, cmd.long)
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+
+	if profile != "" {
+		err := applyProfile(profileFile, profile)
 		if err != nil {
-			panic(err)
+			fmt.Fprintf(os.Stderr, "gostress %s: %s\n", cmd.name, err)
+			os.Exit(2)
 		}
-		err = generateReport()
+	}
+
+	if cmd.needsGOROOT {
+		err := setupGOROOT()
 		if err != nil {
-			panic(err)
+			fmt.Fprintf(os.Stderr, "gostress %s: %s\n", cmd.name, err)
+			os.Exit(2)
 		}
-	} else {
-		fmt.Printf("No valid mode selected\n")
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+	err = cmd.run(cwd, flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gostress %s: %s\n", cmd.name, err)
+		os.Exit(1)
 	}
 }
 
 var iters int
-var mode string
 var timeout int64
 var gomaxproc int
 var reruns int
+var benchHistory string
+var benchAlpha float64
+var benchDelta float64
+var sandbox bool
+var netns bool
+var blacklistFile string
+var profileFile string
+var profile string
+var pkgFilter string
+var runFilter string
+var benchFilter string
+var resume bool
+
+func itersFlag() {
+	flag.IntVar(&iters, "iters", 100, "iterations per goroutine")
+}
 
-const (
-	RUNNER string = "runner"
-	SURVEY string = "survey"
-)
+func rerunsFlag() {
+	flag.IntVar(&reruns, "reruns", 10, "set amount by which each test must be rerun")
+}
 
-func init() {
-	flag.IntVar(&iters, "iters", 100, "iterations per goroutine")
-	flag.StringVar(&mode, "mode", RUNNER, "mode of operation, either \"runner\" or \"survey\"")
+// harnessFlags registers the flags of every command that executes
+// harnesses through executeSingleTest.
+func harnessFlags() {
 	flag.Int64Var(&timeout, "timeout", 600, "timeout for each individual test (seconds)")
 	flag.IntVar(&gomaxproc, "gomaxproc", 10, "set GOMAXPROC value during testing")
-	flag.IntVar(&reruns, "reruns", 10, "set amount by which each test must be rerun")
-	flag.Parse()
+	flag.BoolVar(&sandbox, "sandbox", true, "run each harness in its own directory with a private testdata, TMPDIR and HOME")
+	flag.BoolVar(&netns, "netns", false, "run each harness in its own network namespace (needs unshare)")
+	flag.Int64Var(&limitAS, "limitas", 0, "address space limit of each harness in MB, 0 for none (needs prlimit)")
+	flag.Int64Var(&limitCPU, "limitcpu", 0, "CPU time limit of each harness in seconds, 0 for none")
+	flag.Int64Var(&limitFiles, "limitfiles", 0, "open file limit of each harness, 0 for none")
+	flag.Int64Var(&limitProcs, "limitprocs", 0, "process and thread limit of the user running each harness, 0 for none")
+	flag.BoolVar(&interleave, "interleave", false, "also write stdout and stderr of each harness, timestamped and in order, to one .log file")
+	flag.BoolVar(&keepOutput, "keepoutput", false, "keep the stdout (and .log) of passing runs too, e.g. for benchmark or -testv output")
+	flag.BoolVar(&testVerbose, "testv", false, "run the harnesses with -v")
+	flag.StringVar(&testBenchmarks, "testbenchmarks", "", "run the harnesses with -benchmarks set to this regexp instead of the benchmark of each harness")
+	flag.BoolVar(&coreDumps, "cores", false, "let harnesses killed by a signal dump core (needs prlimit) and collect the core with the artifacts")
+	flag.StringVar(&artifactsDir, "artifacts", "artifacts", "directory to collect the source, binary, output and environment of failed runs in, empty for none")
+}
+
+func blacklistFlag() {
+	flag.StringVar(&blacklistFile, "blacklist", "blacklist", "file listing the tests and packages to skip, empty to skip nothing")
+}
+
+func filterFlags() {
+	flag.StringVar(&pkgFilter, "pkg", "", "only use packages matching this regexp")
+	flag.StringVar(&runFilter, "run", "", "only use tests and examples matching this regexp")
+	flag.StringVar(&benchFilter, "bench", "", "only use benchmarks matching this regexp")
+}
+
+func profileFlags() {
+	flag.StringVar(&profileFile, "config", "gostress.profiles", "file defining the survey profiles")
+	flag.StringVar(&profile, "profile", "", "profile from -config to take the settings from; flags given on the command line win")
+}
+
+func benchFlags() {
+	flag.StringVar(&benchHistory, "benchhistory", "bench.history", "file in which benchmark results are stored between runs")
+	flag.Float64Var(&benchAlpha, "benchalpha", 0.05, "significance level for reporting benchmark differences")
+	flag.Float64Var(&benchDelta, "benchdelta", 5, "slowdown (percent) above which a significant difference is a regression")
+}
+
+func setupGOROOT() os.Error {
 	GOROOT = os.Getenv("GOROOT")
 	if GOROOT == "" {
-		panic("GOROOT not set in environment")
+		return os.NewError("GOROOT not set in environment")
 	}
 	cwd, err := os.Getwd()
 	if err != nil {
-		panic(err)
+		return err
 	}
 	if !path.IsAbs(GOROOT) {
 		GOROOT = path.Join(cwd, GOROOT)
 	}
 	GOROOT = path.Clean(GOROOT)
+	return nil
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"exec"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var limitAS int64
+var limitCPU int64
+var limitFiles int64
+var limitProcs int64
+
+var errResourceLimit = os.NewError("Test case hit a resource limit")
+
+func limitsEnabled() bool {
+	return limitAS > 0 || limitCPU > 0 || limitFiles > 0 || limitProcs > 0
+}
+
+// withLimits starts the command through prlimit(1), which sets the
+// resource limits of the harness (and lifts the core size limit for
+// -cores) and then execs it in place.
+func withLimits(name string, argv []string) (string, []string, os.Error) {
+	if !limitsEnabled() && !coreDumps {
+		return name, argv, nil
+	}
+	prlimit, err := exec.LookPath("prlimit")
+	if err != nil {
+		return "", nil, err
+	}
+	args := []string{prlimit}
+	if limitAS > 0 {
+		args = append(args, "--as="+strconv.Itoa64(limitAS*1024*1024))
+	}
+	if limitCPU > 0 {
+		// at the hard limit the kernel sends SIGKILL, so keep it a
+		// second above the soft limit, which sends SIGXCPU
+		args = append(args, "--cpu="+strconv.Itoa64(limitCPU)+":"+strconv.Itoa64(limitCPU+1))
+	}
+	if limitFiles > 0 {
+		args = append(args, "--nofile="+strconv.Itoa64(limitFiles))
+	}
+	if limitProcs > 0 {
+		args = append(args, "--nproc="+strconv.Itoa64(limitProcs))
+	}
+	if coreDumps {
+		args = append(args, "--core=unlimited")
+	}
+	args = append(args, "--")
+	return prlimit, append(args, argv...), nil
+}
+
+// limitMarkers are what a harness prints when it runs into one of the
+// limits set by withLimits.
+var limitMarkers = map[string]string{
+	"cpu limit exceeded":               "cpu time",
+	"out of memory":                    "address space",
+	"cannot allocate memory":           "address space",
+	"too many open files":              "open files",
+	"resource temporarily unavailable": "processes",
+	"pthread_create failed":            "processes",
+}
+
+// limitSet tells whether the limit a marker points to was set.
+func limitSet(limit string) bool {
+	switch limit {
+	case "cpu time":
+		return limitCPU > 0
+	case "address space":
+		return limitAS > 0
+	case "open files":
+		return limitFiles > 0
+	case "processes":
+		return limitProcs > 0
+	}
+	return false
+}
+
+// resourceLimitHit tells whether a failed harness was stopped by one of
+// the limits that were set, and by which. The runtime catches the SIGXCPU
+// of the soft CPU time limit and exits after printing "SIGXCPU: cpu limit
+// exceeded"; a harness that died of the signal itself counts too. A
+// SIGKILL does not, it may as well come from the timeout.
+func resourceLimitHit(waitMsg *os.Waitmsg, outputFile string) (string, bool) {
+	if !limitsEnabled() {
+		return "", false
+	}
+	if waitMsg.Signaled() && limitCPU > 0 && waitMsg.Signal() == syscall.SIGXCPU {
+		return "cpu time", true
+	}
+	output, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		return "", false
+	}
+	lower := strings.ToLower(string(output))
+	for marker, limit := range limitMarkers {
+		if limitSet(limit) && strings.Contains(lower, marker) {
+			return limit, true
+		}
+	}
+	return "", false
+}
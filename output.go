@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	interleave     bool
+	keepOutput     bool
+	testVerbose    bool
+	testBenchmarks string
+)
+
+// harnessArgs are the arguments every harness is started with.
+func harnessArgs() []string {
+	args := make([]string, 0)
+	if testVerbose {
+		args = append(args, "-v=true")
+	}
+	if testBenchmarks != "" {
+		args = append(args, "-benchmarks="+testBenchmarks)
+	}
+	return args
+}
+
+// capture copies the stdout and stderr of a harness to their own files
+// and, line by line with a timestamp and the name of the stream, to one
+// combined .log file, so the order of the two can be read back.
+type capture struct {
+	stdout, stderr *os.File // the write ends handed to the harness
+	log            *os.File
+	lock           sync.Mutex
+	start          int64
+	done           chan bool
+}
+
+func startCapture(test string, stdout, errLog *os.File) (*capture, os.Error) {
+	log, err := os.Open(test+".log", os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		log.Close()
+		outR.Close()
+		outW.Close()
+		return nil, err
+	}
+	c := &capture{stdout: outW, stderr: errW, log: log, start: time.Nanoseconds(), done: make(chan bool)}
+	go c.copy("stdout", outR, stdout)
+	go c.copy("stderr", errR, errLog)
+	return c, nil
+}
+
+func (c *capture) copy(stream string, r *os.File, w *os.File) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			w.WriteString(line)
+			c.lock.Lock()
+			fmt.Fprintf(c.log, "%10.6f %s %s", float64(time.Nanoseconds()-c.start)/1e9, stream, line)
+			if !strings.HasSuffix(line, "\n") {
+				c.log.WriteString("\n")
+			}
+			c.lock.Unlock()
+		}
+		if err != nil {
+			break
+		}
+	}
+	r.Close()
+	c.done <- true
+}
+
+// closeWriters closes our copies of the write ends once the harness has
+// been started with them, so that the copies see the end of the output
+// when the harness exits.
+func (c *capture) closeWriters() {
+	c.stdout.Close()
+	c.stderr.Close()
+}
+
+// wait waits until all output of the harness has been copied.
+func (c *capture) wait() {
+	<-c.done
+	<-c.done
+	c.log.Close()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+)
+
+// packageRunDir is the directory a harness for pkgName runs in when it is
+// not sandboxed. It holds the package's own testdata, so packages that
+// ship a file of the same name no longer overwrite each other's copy.
+func packageRunDir(cwd string, pkgName string) string {
+	return path.Join(cwd, "work", "pkgdata"+currentToolchain.suffix(), pkgName)
+}
+
+// syncFile copies src to dest unless dest already has the same size and
+// modification time, and gives dest the modification time of src so the
+// next call can tell the two apart.
+func syncFile(dest, src string) os.Error {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	destInfo, err := os.Stat(dest)
+	if err == nil && destInfo.Size == srcInfo.Size && destInfo.Mtime_ns == srcInfo.Mtime_ns {
+		return nil
+	}
+	err = copyFile(dest, src)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(dest, srcInfo.Atime_ns, srcInfo.Mtime_ns)
+}
+
+type syncTreeVisitor struct {
+	copyTreeVisitor
+}
+
+func (v *syncTreeVisitor) VisitFile(pathName string, f *os.FileInfo) {
+	if v.err != nil || !f.IsRegular() {
+		return
+	}
+	v.err = syncFile(v.target(pathName), pathName)
+}
+
+// syncTree is copyTree for trees that are copied again and again; only
+// files that changed since the last copy are written.
+func syncTree(dest, src string) os.Error {
+	src = path.Clean(src)
+	v := &syncTreeVisitor{copyTreeVisitor{src, path.Clean(dest), nil}}
+	errors := make(chan os.Error, 64)
+	path.Walk(src, v, errors)
+	close(errors)
+	for err := range errors {
+		if v.err == nil {
+			v.err = err
+		}
+	}
+	return v.err
+}
+
+type testdataVisitor struct {
+	dirs []string
+}
+
+func (v *testdataVisitor) VisitDir(pathName string, f *os.FileInfo) bool {
+	if path.Base(pathName) == "testdata" {
+		v.dirs = append(v.dirs, pathName)
+		return false
+	}
+	return true
+}
+
+func (v *testdataVisitor) VisitFile(pathName string, f *os.FileInfo) {}
+
+// toolchainStamp describes the toolchain a workspace was prepared with.
+// A workspace whose stamp differs from the current one is rebuilt.
+func toolchainStamp() (string, os.Error) {
+	compiler, linker := currentToolchain.tools()
+	stamp := bytes.NewBufferString("")
+	fmt.Fprintf(stamp, "GOROOT=%s\n", GOROOT)
+	fmt.Fprintf(stamp, "GOOS=%s\nGOARCH=%s\n", runtime.GOOS, runtime.GOARCH)
+	for _, tool := range []string{compiler, linker} {
+		info, err := os.Stat(tool)
+		if err != nil {
+			return "", os.NewError("toolchain incomplete: " + err.String())
+		}
+		fmt.Fprintf(stamp, "%s %d %d\n", tool, info.Size, info.Mtime_ns)
+	}
+	return stamp.String(), nil
+}
+
+// checkWorkspace fails unless the stress workspace of the current
+// toolchain was prepared with the toolchain as it is now.
+func checkWorkspace(cwd string) os.Error {
+	stamp, err := toolchainStamp()
+	if err != nil {
+		return err
+	}
+	old, err := ioutil.ReadFile(path.Join(currentToolchain.workspace(cwd), "TOOLCHAIN"))
+	if err != nil {
+		return os.NewError("no stress workspace, run gostress prepare")
+	}
+	if string(old) != stamp {
+		return os.NewError("toolchain changed since the workspace was prepared, run gostress prepare")
+	}
+	return nil
+}
+
+// prepareWorkspace builds the stress workspace of the current toolchain
+// in cwd: a copy of the installed packages with the test builds of every
+// package on top in go.gostress, and the testdata of every package below
+// work/pkgdata. Named toolchains get their own go.gostress.name and
+// work/pkgdata.name. Running it again only copies what changed.
+func prepareWorkspace(cwd string) os.Error {
+	testRoot := currentToolchain.workspace(cwd)
+	stampFile := path.Join(testRoot, "TOOLCHAIN")
+
+	stamp, err := toolchainStamp()
+	if err != nil {
+		return err
+	}
+	pkgRoot := path.Join(GOROOT, "pkg", runtime.GOOS+"_"+runtime.GOARCH)
+	if _, err := os.Stat(pkgRoot); err != nil {
+		return os.NewError("no installed packages for " + runtime.GOOS + "_" + runtime.GOARCH + ", run all.bash first")
+	}
+	pkgDirs := findPackageDirs()
+	if len(pkgDirs) == 0 {
+		return os.NewError("no package test builds found, run \"make test\" in $GOROOT/src/pkg first")
+	}
+
+	old, err := ioutil.ReadFile(stampFile)
+	if err == nil && string(old) != stamp {
+		fmt.Printf("toolchain changed, rebuilding workspace\n")
+		err = os.RemoveAll(testRoot)
+		if err != nil {
+			return err
+		}
+		err = os.RemoveAll(packageRunDir(cwd, ""))
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("syncing %s\n", path.Join(GOROOT, "pkg"))
+	err = syncTree(path.Join(testRoot, "pkg"), path.Join(GOROOT, "pkg"))
+	if err != nil {
+		return err
+	}
+	err = copyTestPackages(testRoot, pkgDirs)
+	if err != nil {
+		return err
+	}
+
+	v := new(testdataVisitor)
+	path.Walk(path.Join(GOROOT, "src", "pkg"), v, nil)
+	for _, dir := range v.dirs {
+		pkgDir, _ := path.Split(dir)
+		pkgName := packageName(path.Clean(pkgDir))
+		fmt.Printf("syncing testdata of %s\n", pkgName)
+		err = syncTree(path.Join(packageRunDir(cwd, pkgName), "testdata"), dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	return ioutil.WriteFile(stampFile, []byte(stamp), 0664)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// stressors are extra environment settings, such as GOGC=1, handed to
+// every harness run.
+var stressors []string
+
+// commandFlags lists the flags of all commands. The flag package only
+// knows those of the command being run, and a profile key that names none
+// of these is a typo rather than a setting for another command. Keep it
+// in step with the *Flags functions.
+var commandFlags = []string{
+	"adaptive", "artifacts", "bad", "bench", "benchalpha", "benchdelta",
+	"benchhistory", "blacklist", "budget", "cgroup", "cilevel", "ciwidth",
+	"confidence", "config", "cores", "cpuquotas", "cpusets", "gogcs",
+	"gomaxproc", "good", "gotracebacks",
+	"interleave", "iters", "keepoutput", "limitas", "limitcpu",
+	"limitfiles", "limitprocs", "maxreruns", "maxruns", "minruns", "netns",
+	"pkg", "profile", "reruns", "resume", "run", "sandbox", "src",
+	"testbenchmarks", "testv", "timeout", "toolchains",
+}
+
+// loadProfiles reads a profile file. Each profile starts with a [name]
+// line and is followed by "key = value" lines; # starts a comment.
+func loadProfiles(filename string) (map[string]map[string]string, os.Error) {
+	file, err := os.Open(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	profiles := make(map[string]map[string]string)
+	var current map[string]string
+	reader := bufio.NewReader(file)
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != os.EOF {
+			return nil, err
+		}
+		done := err == os.EOF
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			current = make(map[string]string)
+			profiles[strings.TrimSpace(line[1:len(line)-1])] = current
+		default:
+			eq := strings.Index(line, "=")
+			if eq < 0 || current == nil {
+				return nil, os.NewError(fmt.Sprintf("%s:%d: expected [profile] or key = value", filename, lineNo))
+			}
+			current[strings.TrimSpace(line[:eq])] = strings.TrimSpace(line[eq+1:])
+		}
+		if done {
+			break
+		}
+	}
+	return profiles, nil
+}
+
+// applyProfile takes the settings of the named profile. Keys naming a flag
+// set that flag unless it was given on the command line; "packages" and
+// "stressors" are lists separated by spaces. A profile is shared by all
+// commands, so keys naming a flag of another command are skipped; any
+// other key is an error.
+func applyProfile(filename string, name string) os.Error {
+	profiles, err := loadProfiles(filename)
+	if err != nil {
+		return err
+	}
+	settings, ok := profiles[name]
+	if !ok {
+		return os.NewError("no profile " + name + " in " + filename)
+	}
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for key, value := range settings {
+		switch {
+		case key == "packages":
+			packagePatterns = strings.Fields(value)
+		case key == "stressors":
+			stressors = strings.Fields(value)
+		case flag.Lookup(key) != nil:
+			if explicit[key] {
+				continue
+			}
+			if !flag.Set(key, value) {
+				return os.NewError(fmt.Sprintf("profile %s: bad value %q for %s", name, value, key))
+			}
+		case !listContains(commandFlags, key):
+			return os.NewError(fmt.Sprintf("profile %s: unknown setting %s", name, key))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const tiocgwinsz = 0x5413
+
+// isTerminal reports whether f is a terminal, by asking for its window
+// size the way terminal libraries do.
+func isTerminal(f *os.File) bool {
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	var winsize [4]uint16
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(f.Fd()), tiocgwinsz, uintptr(unsafe.Pointer(&winsize)))
+	return errno == 0
+}
+
+func formatDuration(ns int64) string {
+	s := ns / 1e9
+	if s >= 3600 {
+		return fmt.Sprintf("%dh%02dm%02ds", s/3600, s/60%60, s%60)
+	}
+	if s >= 60 {
+		return fmt.Sprintf("%dm%02ds", s/60, s%60)
+	}
+	return fmt.Sprintf("%ds", s)
+}
+
+// progress keeps the counters of a survey and shows them after every run.
+// On a terminal a single status line is redrawn; elsewhere, such as in CI
+// logs, every run gets a plain line of its own.
+type progress struct {
+	total                   int
+	done                    int
+	passed, failed, skipped int
+	start                   int64
+	runStart                int64
+	ranTime                 int64
+	ran                     int
+	current                 string
+	tty                     bool
+}
+
+func newProgress(total int) *progress {
+	return &progress{total: total, start: time.Nanoseconds(), tty: isTerminal(os.Stdout)}
+}
+
+// eta estimates the time left from the mean duration of the runs that
+// were not skipped so far.
+func (p *progress) eta() string {
+	if p.ran == 0 || p.done >= p.total {
+		// -adaptive runs can go past the planned total
+		return "?"
+	}
+	return formatDuration(p.ranTime / int64(p.ran) * int64(p.total-p.done))
+}
+
+func (p *progress) status() string {
+	return fmt.Sprintf("[%d/%d passed %d failed %d skipped %d, %s elapsed, eta %s]",
+		p.done, p.total, p.passed, p.failed, p.skipped, formatDuration(time.Nanoseconds()-p.start), p.eta())
+}
+
+func (p *progress) begin(name string) {
+	p.current = name
+	p.runStart = time.Nanoseconds()
+	if p.tty {
+		fmt.Printf("\r\033[K%s %s", p.status(), name)
+	}
+}
+
+// end records the result of the current run, one of "passed", "failed",
+// "resource-limit", "skipped" or "interrupted".
+func (p *progress) end(result string) {
+	p.count(result)
+	if result != "skipped" {
+		p.ranTime += time.Nanoseconds() - p.runStart
+		p.ran++
+	}
+	if !p.tty {
+		fmt.Printf("%s %s, %s\n", p.status(), p.current, result)
+		return
+	}
+	// keep failures on screen, the status line is redrawn for every run
+	if result == "failed" || result == "resource-limit" {
+		fmt.Printf("\r\033[K%s, %s\n", p.current, result)
+	}
+	fmt.Printf("\r\033[K%s", p.status())
+}
+
+func (p *progress) count(result string) {
+	p.done++
+	switch result {
+	case "passed":
+		p.passed++
+	case "failed", "resource-limit":
+		p.failed++
+	case "skipped":
+		p.skipped++
+	}
+}
+
+// restore counts a run finished by an earlier, interrupted survey.
+func (p *progress) restore(result string) {
+	p.count(result)
+}
+
+func (p *progress) finish() {
+	if p.tty {
+		fmt.Printf("\n")
+	}
+}
@@ -1,18 +1,19 @@
 #!/bin/sh
 set -xe
 
-rm -rf go.gostress testdata
+rm -rf testdata
 rm -rf output/*
 rm -rf *.output
 
-make nuke
 make
 
-mkdir -p go.gostress
-cp -a $GOROOT/pkg go.gostress
+./gostress prepare
+
+# the runner runs every package in one process, so it still needs all
+# testdata merged into the current directory
 find $GOROOT/src/pkg -name 'testdata' -type d | xargs -I DIR cp -a -i DIR .
 
-./gostress -iters=100
+./gostress run -iters=100
 
 rm -rf *.go.6
 
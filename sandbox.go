@@ -0,0 +1,91 @@
+package main
+
+import (
+	"exec"
+	"os"
+	"path"
+)
+
+type copyTreeVisitor struct {
+	src, dest string
+	err       os.Error
+}
+
+func (v *copyTreeVisitor) target(pathName string) string {
+	return path.Join(v.dest, pathName[len(v.src):])
+}
+
+func (v *copyTreeVisitor) VisitDir(pathName string, f *os.FileInfo) bool {
+	if v.err != nil {
+		return false
+	}
+	v.err = os.MkdirAll(v.target(pathName), 0764)
+	return v.err == nil
+}
+
+func (v *copyTreeVisitor) VisitFile(pathName string, f *os.FileInfo) {
+	if v.err != nil || !f.IsRegular() {
+		return
+	}
+	v.err = copyFile(v.target(pathName), pathName)
+}
+
+// copyTree copies the regular files and directories below src to dest.
+func copyTree(dest, src string) os.Error {
+	src = path.Clean(src)
+	v := &copyTreeVisitor{src, path.Clean(dest), nil}
+	errors := make(chan os.Error, 64)
+	path.Walk(src, v, errors)
+	close(errors)
+	for err := range errors {
+		if v.err == nil {
+			v.err = err
+		}
+	}
+	return v.err
+}
+
+// createSandbox creates a private working directory for one harness run
+// below work/sandbox, with its own copy of the package's testdata and its
+// own TMPDIR and HOME, so that runs do not trip over each other's files.
+func createSandbox(cwd string, test string, pkgName string) (string, os.Error) {
+	dir := path.Join(cwd, "work", "sandbox", test)
+	err := os.RemoveAll(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, sub := range []string{"tmp", "home"} {
+		err = os.MkdirAll(path.Join(dir, sub), 0764)
+		if err != nil {
+			return "", err
+		}
+	}
+	testdata := path.Join(packageRunDir(cwd, pkgName), "testdata")
+	if _, err := os.Stat(testdata); err == nil {
+		err = copyTree(path.Join(dir, "testdata"), testdata)
+		if err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+func sandboxEnv(dir string) []string {
+	return []string{"TMPDIR=" + path.Join(dir, "tmp"), "HOME=" + path.Join(dir, "home")}
+}
+
+// harnessCommand returns the program and arguments that start the harness
+// binary. With -netns the harness is started through unshare(1) in a new
+// network namespace with only the loopback interface, so tests listening
+// on fixed ports cannot collide with other runs.
+func harnessCommand(binary string) (string, []string, os.Error) {
+	if !netns {
+		return binary, append([]string{binary}, harnessArgs()...), nil
+	}
+	unshare, err := exec.LookPath("unshare")
+	if err != nil {
+		return "", nil, err
+	}
+	argv := []string{unshare, "--net", "--map-root-user", "--", "/bin/sh", "-c", "ip link set lo up 2>/dev/null; exec \"$0\" \"$@\"", binary}
+	return unshare, append(argv, harnessArgs()...), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// packagePatterns restricts discovery to the matching packages. A pattern
+// ending in "/..." also matches every package below it.
+var packagePatterns []string
+
+func matchPackage(pattern string, pkgName string) bool {
+	if strings.HasSuffix(pattern, "/...") {
+		prefix := pattern[:len(pattern)-len("/...")]
+		return pkgName == prefix || strings.HasPrefix(pkgName, prefix+"/")
+	}
+	return pattern == pkgName
+}
+
+func compileFilter(expr string) (*regexp.Regexp, os.Error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+func filterNames(names []string, re *regexp.Regexp) []string {
+	if re == nil {
+		return names
+	}
+	kept := make([]string, 0, len(names))
+	for _, name := range names {
+		if re.MatchString(name) {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
+
+// selectTestMains applies the package patterns of the profile and the
+// -pkg, -run and -bench filters, and drops the packages left with
+// nothing to run. As with go test, -run without -bench selects no
+// benchmarks.
+func selectTestMains(testMains []*TestMain) ([]*TestMain, os.Error) {
+	pkgRe, err := compileFilter(pkgFilter)
+	if err != nil {
+		return nil, err
+	}
+	runRe, err := compileFilter(runFilter)
+	if err != nil {
+		return nil, err
+	}
+	benchRe, err := compileFilter(benchFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make([]*TestMain, 0, len(testMains))
+	for _, testMain := range testMains {
+		if len(packagePatterns) > 0 {
+			matched := false
+			for _, pattern := range packagePatterns {
+				if matchPackage(pattern, testMain.pkgName) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+		}
+		if pkgRe != nil && !pkgRe.MatchString(testMain.pkgName) {
+			continue
+		}
+		testMain.tests = filterNames(testMain.tests, runRe)
+		testMain.examples = filterNames(testMain.examples, runRe)
+		if runRe != nil && benchRe == nil {
+			testMain.benchmarks = nil
+		} else {
+			testMain.benchmarks = filterNames(testMain.benchmarks, benchRe)
+		}
+		if len(testMain.tests) == 0 && len(testMain.benchmarks) == 0 && len(testMain.examples) == 0 {
+			continue
+		}
+		selected = append(selected, testMain)
+	}
+	return selected, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"exec"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// children are the processes gostress is waiting for, mapped to whether
+// they lead a process group of their own.
+var children = make(map[int]bool)
+var childrenLock sync.Mutex
+
+// interrupted and surveyActive are guarded by childrenLock as well.
+var interrupted bool
+
+// surveyActive is set while a survey runs; the survey then notices the
+// interruption itself and writes out its partial results before exiting.
+var surveyActive bool
+
+func setSurveyActive(active bool) {
+	childrenLock.Lock()
+	surveyActive = active
+	childrenLock.Unlock()
+}
+
+// trackChild adds a started child to the ones killChildren kills. A child
+// that was started after killChildren ran is killed right away.
+func trackChild(pid int, group bool) {
+	childrenLock.Lock()
+	defer childrenLock.Unlock()
+	children[pid] = group
+	if interrupted {
+		killChild(pid, group)
+	}
+}
+
+func killChild(pid int, group bool) {
+	if group {
+		syscall.Kill(-pid, syscall.SIGKILL)
+	} else {
+		syscall.Kill(pid, syscall.SIGKILL)
+	}
+}
+
+func untrackChild(pid int) {
+	childrenLock.Lock()
+	children[pid] = false, false
+	childrenLock.Unlock()
+}
+
+func isInterrupted() bool {
+	childrenLock.Lock()
+	defer childrenLock.Unlock()
+	return interrupted
+}
+
+// killChildren kills every process gostress waits for, and for harnesses
+// every process they started as well.
+func killChildren() {
+	childrenLock.Lock()
+	defer childrenLock.Unlock()
+	for pid, group := range children {
+		killChild(pid, group)
+	}
+}
+
+// inNewProcessGroup starts the command through setsid(1), which makes the
+// harness lead a new process group so killChildren also reaches whatever
+// the tests started. setsid execs the command in place, so the pid stays
+// the harness's own. Without setsid only the harness itself is killed.
+func inNewProcessGroup(name string, argv []string) (string, []string, bool) {
+	setsid, err := exec.LookPath("setsid")
+	if err != nil {
+		return name, argv, false
+	}
+	return setsid, append([]string{setsid}, argv...), true
+}
+
+// handleSignals kills the children on SIGINT or SIGTERM. Outside of a
+// survey gostress exits right away; a second signal always does.
+func handleSignals() {
+	for sig := range signal.Incoming {
+		usig, ok := sig.(signal.UnixSignal)
+		if !ok || (usig != syscall.SIGINT && usig != syscall.SIGTERM) {
+			continue
+		}
+		childrenLock.Lock()
+		again := interrupted
+		interrupted = true
+		inSurvey := surveyActive
+		childrenLock.Unlock()
+
+		killChildren()
+		if again || !inSurvey {
+			fmt.Fprintf(os.Stderr, "\ngostress: %s, exiting\n", sig)
+			os.Exit(130)
+		}
+	}
+}
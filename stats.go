@@ -0,0 +1,160 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+func mean(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, s := range samples {
+		sum += s
+	}
+	return sum / float64(len(samples))
+}
+
+func stddev(samples []float64) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	m := mean(samples)
+	sum := 0.0
+	for _, s := range samples {
+		sum += (s - m) * (s - m)
+	}
+	return math.Sqrt(sum / float64(len(samples)-1))
+}
+
+func sortedCopy(samples []float64) []float64 {
+	sorted := make([]float64, len(samples))
+	copy(sorted, samples)
+	sort.SortFloat64s(sorted)
+	return sorted
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+// removeOutliers drops samples outside 1.5 interquartile ranges of the
+// quartiles, the same rule benchstat uses before summarizing.
+func removeOutliers(samples []float64) []float64 {
+	sorted := sortedCopy(samples)
+	q1 := percentile(sorted, 0.25)
+	q3 := percentile(sorted, 0.75)
+	lo := q1 - 1.5*(q3-q1)
+	hi := q3 + 1.5*(q3-q1)
+	kept := make([]float64, 0, len(samples))
+	for _, s := range samples {
+		if s >= lo && s <= hi {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// mannWhitneyU returns the two-sided p-value of the Mann-Whitney U-test
+// for the samples a and b, using the normal approximation with a
+// correction for ties.
+func mannWhitneyU(a, b []float64) float64 {
+	n1 := float64(len(a))
+	n2 := float64(len(b))
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+
+	type ranked struct {
+		value float64
+		first bool
+	}
+	all := make([]ranked, 0, len(a)+len(b))
+	for _, v := range a {
+		all = append(all, ranked{v, true})
+	}
+	for _, v := range b {
+		all = append(all, ranked{v, false})
+	}
+	// insertion sort keeps this free of another sort.Interface type
+	for i := 1; i < len(all); i++ {
+		for j := i; j > 0 && all[j].value < all[j-1].value; j-- {
+			all[j], all[j-1] = all[j-1], all[j]
+		}
+	}
+
+	rankSum := 0.0
+	tieTerm := 0.0
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].first {
+				rankSum += rank
+			}
+		}
+		t := float64(j - i)
+		tieTerm += t*t*t - t
+		i = j
+	}
+
+	u := rankSum - n1*(n1+1)/2
+	n := n1 + n2
+	mu := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - tieTerm/(n*(n-1))))
+	if sigma == 0 {
+		return 1
+	}
+	z := (math.Fabs(u-mu) - 0.5) / sigma
+	if z < 0 {
+		z = 0
+	}
+	return math.Erfc(z / math.Sqrt2)
+}
+
+// normalQuantile returns z such that a standard normal variable lies in
+// [-z, z] with probability level.
+func normalQuantile(level float64) float64 {
+	lo, hi := 0.0, 10.0
+	for i := 0; i < 100; i++ {
+		mid := (lo + hi) / 2
+		if math.Erfc(mid/math.Sqrt2) > 1-level {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// wilson returns the Wilson score interval of a failure rate of failures
+// in runs at the given confidence level. Unlike the normal approximation
+// it stays inside [0, 1] and is useful with no failures at all.
+func wilson(failures, runs int, level float64) (lo, hi float64) {
+	if runs == 0 {
+		return 0, 1
+	}
+	z := normalQuantile(level)
+	n := float64(runs)
+	p := float64(failures) / n
+	center := (p + z*z/(2*n)) / (1 + z*z/n)
+	half := z / (1 + z*z/n) * math.Sqrt(p*(1-p)/n+z*z/(4*n*n))
+	lo, hi = center-half, center+half
+	if lo < 0 {
+		lo = 0
+	}
+	if hi > 1 {
+		hi = 1
+	}
+	return lo, hi
+}
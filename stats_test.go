@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func near(got, want, tolerance float64) bool {
+	return math.Fabs(got-want) <= tolerance
+}
+
+func TestMeanStddev(t *testing.T) {
+	samples := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+	if m := mean(samples); m != 5 {
+		t.Errorf("mean = %g, want 5", m)
+	}
+	if s := stddev(samples); !near(s, math.Sqrt(32.0/7), 1e-12) {
+		t.Errorf("stddev = %g, want %g", s, math.Sqrt(32.0/7))
+	}
+}
+
+func TestNormalQuantile(t *testing.T) {
+	for _, c := range []struct{ level, z float64 }{
+		{0.90, 1.644854},
+		{0.95, 1.959964},
+		{0.99, 2.575829},
+	} {
+		if z := normalQuantile(c.level); !near(z, c.z, 1e-6) {
+			t.Errorf("normalQuantile(%g) = %g, want %g", c.level, z, c.z)
+		}
+	}
+}
+
+func TestWilson(t *testing.T) {
+	for _, c := range []struct {
+		failures, runs int
+		lo, hi         float64
+	}{
+		{3, 10, 0.1078, 0.6032},
+		{0, 10, 0, 0.2775},
+		{10, 10, 0.7225, 1},
+		{0, 0, 0, 1},
+	} {
+		lo, hi := wilson(c.failures, c.runs, 0.95)
+		if !near(lo, c.lo, 1e-4) || !near(hi, c.hi, 1e-4) {
+			t.Errorf("wilson(%d, %d) = [%.4f, %.4f], want [%.4f, %.4f]", c.failures, c.runs, lo, hi, c.lo, c.hi)
+		}
+	}
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	for _, c := range []struct {
+		a, b []float64
+		p    float64
+	}{
+		// wilcox.test(1:5, 6:10, exact=FALSE) in R
+		{[]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 0.01219},
+		{[]float64{6, 7, 8, 9, 10}, []float64{1, 2, 3, 4, 5}, 0.01219},
+		{[]float64{3, 3, 3}, []float64{3, 3, 3}, 1},
+		{[]float64{1, 2}, nil, 1},
+	} {
+		if p := mannWhitneyU(c.a, c.b); !near(p, c.p, 1e-5) {
+			t.Errorf("mannWhitneyU(%v, %v) = %.5f, want %.5f", c.a, c.b, p, c.p)
+		}
+	}
+}
+
+func TestFisherExact(t *testing.T) {
+	for _, c := range []struct {
+		failures1, runs1, failures2, runs2 int
+		p                                  float64
+	}{
+		// the lady tasting tea
+		{3, 4, 1, 4, 0.485714},
+		{1, 10, 11, 14, 0.002759},
+		{0, 10, 0, 10, 1},
+	} {
+		if p := fisherExact(c.failures1, c.runs1, c.failures2, c.runs2); !near(p, c.p, 1e-6) {
+			t.Errorf("fisherExact(%d/%d, %d/%d) = %.6f, want %.6f", c.failures1, c.runs1, c.failures2, c.runs2, p, c.p)
+		}
+	}
+}
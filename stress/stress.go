@@ -0,0 +1,111 @@
+// Package stress runs existing test functions from many goroutines at
+// once, the way the harnesses generated by gostress do, for code that
+// would rather call a function than generate and compile a harness.
+package stress
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Config describes how many goroutines run the code under stress.
+type Config struct {
+	// Goroutines is the number of goroutines running at once; 0 means
+	// that of DefaultConfig.
+	Goroutines int
+	// Iterations is the number of times each goroutine runs; 0 means 1.
+	Iterations int
+}
+
+// DefaultConfig matches the survey defaults of gostress.
+var DefaultConfig = Config{Goroutines: 100, Iterations: 1}
+
+func (cfg *Config) run(f func()) {
+	if cfg == nil {
+		cfg = &DefaultConfig
+	}
+	goroutines, iterations := cfg.Goroutines, cfg.Iterations
+	if goroutines <= 0 {
+		goroutines = DefaultConfig.Goroutines
+	}
+	if iterations <= 0 {
+		iterations = 1
+	}
+	wg := new(sync.WaitGroup)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			for j := 0; j < iterations; j++ {
+				f()
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+}
+
+// Run runs tests and benchmarks concurrently as described by cfg, through
+// testing.Main and testing.RunBenchmarks like a generated harness. As in
+// a harness, the first failing test ends the program. Unless -benchmarks
+// is given on the command line, all of benchmarks are run.
+func Run(tests []testing.InternalTest, benchmarks []testing.InternalBenchmark, cfg *Config) {
+	// RunBenchmarks runs nothing unless -benchmarks is set
+	flag.Parse()
+	if len(benchmarks) > 0 && flag.Lookup("benchmarks").Value.String() == "" {
+		names := make([]string, len(benchmarks))
+		for i, b := range benchmarks {
+			names[i] = regexp.QuoteMeta(b.Name)
+		}
+		flag.Set("benchmarks", "^("+strings.Join(names, "|")+")$")
+	}
+	cfg.run(func() {
+		if len(tests) > 0 {
+			testing.Main(regexp.MatchString, tests)
+		}
+		if len(benchmarks) > 0 {
+			testing.RunBenchmarks(regexp.MatchString, benchmarks)
+		}
+	})
+}
+
+// Stress calls fn concurrently as described by cfg and reports every
+// error it returns, and every panic, through t. Errors are collected and
+// reported from the calling goroutine, since t is not safe for concurrent
+// use.
+func Stress(t *testing.T, fn func() os.Error, cfg *Config) {
+	errors := make(chan os.Error, 100)
+	done := make(chan bool)
+	go func() {
+		cfg.run(func() {
+			defer func() {
+				if v := recover(); v != nil {
+					errors <- os.NewError(fmt.Sprintf("panic: %v", v))
+				}
+			}()
+			if err := fn(); err != nil {
+				errors <- err
+			}
+		})
+		done <- true
+	}()
+	for {
+		select {
+		case err := <-errors:
+			t.Error(err.String())
+		case <-done:
+			for {
+				select {
+				case err := <-errors:
+					t.Error(err.String())
+				default:
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package stress
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var lock sync.Mutex
+	testRuns, benchRuns := 0, 0
+	tests := []testing.InternalTest{
+		{"stress.TestCount", func(*testing.T) {
+			lock.Lock()
+			testRuns++
+			lock.Unlock()
+		}},
+	}
+	benchmarks := []testing.InternalBenchmark{
+		{"stress.BenchmarkCount", func(b *testing.B) {
+			lock.Lock()
+			benchRuns++
+			lock.Unlock()
+			for i := 0; i < b.N; i++ {
+			}
+		}},
+	}
+	Run(tests, benchmarks, &Config{Goroutines: 4, Iterations: 2})
+	if testRuns != 8 {
+		t.Errorf("test ran %d times, want 8", testRuns)
+	}
+	if benchRuns < 8 {
+		t.Errorf("benchmark ran %d times, want at least 8", benchRuns)
+	}
+}
+
+func TestRunZeroConfig(t *testing.T) {
+	var lock sync.Mutex
+	runs := 0
+	tests := []testing.InternalTest{
+		{"stress.TestCount", func(*testing.T) {
+			lock.Lock()
+			runs++
+			lock.Unlock()
+		}},
+	}
+	Run(tests, nil, &Config{})
+	if runs != DefaultConfig.Goroutines {
+		t.Errorf("test ran %d times, want %d", runs, DefaultConfig.Goroutines)
+	}
+}
@@ -1,27 +1,23 @@
 #!/bin/sh
 set -xe
 
-rm -rf go.gostress testdata
-rm -rf work
+rm -rf work/sandbox
+rm -rf artifacts
 rm -rf output/*
 
-make nuke
 make
 
-mkdir -p go.gostress
-mkdir -p work
-cp -a $GOROOT/pkg go.gostress
-find $GOROOT/src/pkg -name 'testdata' -type d | xargs -I DIR cp -a DIR .
-cp -r testdata work/
-cp -r go.gostress work/
+./gostress prepare
 
-./gostress -iters=10 -gomaxproc=10 -mode="survey" -timeout=180 -reruns=3
+./gostress survey -profile=nightly
 
 rm -rf *.go.6
 
 rm -rf sTest*
 rm -rf pTest*
 rm -rf *.output
+rm -rf *.stdout
+rm -rf *.log
 
 #GOROOT=`pwd`/go.gostress 6g -e -o go.6 sTestxml20.go
 #GOROOT=`pwd`/go.gostress 6l -o go go.6
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"exec"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// condition is one point of the sweep matrix: the scheduler conditions
+// and runtime settings a harness runs under. A survey runs every test
+// -reruns times under each condition.
+type condition struct {
+	name     string
+	cpuQuota string
+	cpuSet   string
+	env      []string
+}
+
+var cpuQuotas string
+var cpuSets string
+var cgroupRoot string
+var gogcs string
+var gotracebacks string
+
+// currentCondition is the condition harnesses are started under.
+var currentCondition = &condition{name: "default"}
+
+func sweepFlags() {
+	flag.StringVar(&cpuQuotas, "cpuquotas", "", "space separated CPU quotas (in CPUs, e.g. \"2 0.5\") to run harnesses under, through cgroup v2")
+	flag.StringVar(&cpuSets, "cpusets", "", "space separated CPU affinity lists (e.g. \"0-1 0\") to run harnesses under, through taskset")
+	flag.StringVar(&cgroupRoot, "cgroup", "/sys/fs/cgroup/gostress", "delegated cgroup v2 directory in which -cpuquotas creates its leaves")
+	flag.StringVar(&gogcs, "gogcs", "", "space separated GOGC values to run harnesses under (e.g. \"1 100 off\")")
+	flag.StringVar(&gotracebacks, "gotracebacks", "", "space separated GOTRACEBACK levels to run harnesses under (e.g. \"0 2\")")
+}
+
+// envAxes are the runtime knobs set through the environment, in the order
+// they appear in condition names. This runtime reads no GODEBUG or
+// GOMEMLIMIT, so those are not swept.
+func envAxes() [][]string {
+	axes := [][]string{}
+	for _, knob := range []struct {
+		name   string
+		values string
+	}{
+		{"GOGC", gogcs},
+		{"GOTRACEBACK", gotracebacks},
+	} {
+		settings := []string{}
+		for _, value := range axis(knob.values) {
+			if value == "" {
+				settings = append(settings, "")
+			} else {
+				settings = append(settings, knob.name+"="+value)
+			}
+		}
+		axes = append(axes, settings)
+	}
+	return axes
+}
+
+// axis returns the values of a space separated sweep flag; an empty flag
+// is a single unrestricted value.
+func axis(values string) []string {
+	fields := strings.Fields(values)
+	if len(fields) == 0 {
+		return []string{""}
+	}
+	return fields
+}
+
+// sweepConditions returns every combination of the sweep axes.
+func sweepConditions() []*condition {
+	envs := [][]string{{}}
+	for _, settings := range envAxes() {
+		combined := make([][]string, 0)
+		for _, env := range envs {
+			for _, setting := range settings {
+				next := make([]string, len(env), len(env)+1)
+				copy(next, env)
+				if setting != "" {
+					next = append(next, setting)
+				}
+				combined = append(combined, next)
+			}
+		}
+		envs = combined
+	}
+
+	conditions := make([]*condition, 0)
+	for _, quota := range axis(cpuQuotas) {
+		for _, cpuSet := range axis(cpuSets) {
+			for _, env := range envs {
+				c := &condition{cpuQuota: quota, cpuSet: cpuSet, env: env}
+				c.name = c.describe()
+				conditions = append(conditions, c)
+			}
+		}
+	}
+	return conditions
+}
+
+func (c *condition) describe() string {
+	parts := make([]string, 0)
+	if c.cpuQuota != "" {
+		parts = append(parts, "cpuquota="+c.cpuQuota)
+	}
+	if c.cpuSet != "" {
+		parts = append(parts, "cpuset="+c.cpuSet)
+	}
+	parts = append(parts, c.env...)
+	if len(parts) == 0 {
+		return "default"
+	}
+	return strings.Join(parts, ",")
+}
+
+// runsPerTest is the number of runs a survey makes of every test.
+func runsPerTest() int {
+	return reruns * len(sweepConditions())
+}
+
+// conditionOfRun returns the condition of the nthTime run of a test. With
+// -budget a test may get fewer runs than -reruns per condition, so the
+// conditions take turns run by run instead.
+func conditionOfRun(nthTime int) *condition {
+	conditions := sweepConditions()
+	if budgetFlag != "" {
+		return conditions[nthTime%len(conditions)]
+	}
+	return conditions[nthTime/reruns%len(conditions)]
+}
+
+// cgroupLeaf creates the cgroup for the CPU quota of c, allowing quota
+// CPUs worth of time every 100ms period.
+func (c *condition) cgroupLeaf() (string, os.Error) {
+	cpus, err := strconv.Atof64(c.cpuQuota)
+	if err != nil || cpus <= 0 {
+		return "", os.NewError("bad CPU quota " + c.cpuQuota)
+	}
+	leaf := path.Join(cgroupRoot, "quota-"+c.cpuQuota)
+	err = os.MkdirAll(leaf, 0755)
+	if err != nil {
+		return "", err
+	}
+	// the controller may already be enabled, the write below tells
+	ioutil.WriteFile(path.Join(cgroupRoot, "cgroup.subtree_control"), []byte("+cpu"), 0644)
+	cpuMax := fmt.Sprintf("%d 100000", int64(cpus*100000))
+	err = ioutil.WriteFile(path.Join(leaf, "cpu.max"), []byte(cpuMax), 0644)
+	if err != nil {
+		return "", err
+	}
+	return leaf, nil
+}
+
+// underCondition wraps the command so that it runs under c: pinned with
+// taskset(1) to the CPUs of the affinity list, and moved into the cgroup
+// of the CPU quota by a shell that then execs the command in place.
+func underCondition(c *condition, name string, argv []string) (string, []string, os.Error) {
+	if c.cpuSet != "" {
+		taskset, err := exec.LookPath("taskset")
+		if err != nil {
+			return "", nil, err
+		}
+		name, argv = taskset, append([]string{taskset, "-c", c.cpuSet}, argv...)
+	}
+	if c.cpuQuota != "" {
+		leaf, err := c.cgroupLeaf()
+		if err != nil {
+			return "", nil, err
+		}
+		script := "echo $$ > " + path.Join(leaf, "cgroup.procs") + " && exec \"$0\" \"$@\""
+		name, argv = "/bin/sh", append([]string{"/bin/sh", "-c", script}, argv...)
+	}
+	return name, argv, nil
+}
+
+// conditionOfOutput returns the condition recorded at the top of the
+// output of a failed run, or "" for the default condition.
+func conditionOfOutput(outputFile string) string {
+	data, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		return ""
+	}
+	const prefix = "GOSTRESS CONDITION: "
+	line := string(data)
+	if i := strings.Index(line, "\n"); i >= 0 {
+		line = line[:i]
+	}
+	if !strings.HasPrefix(line, prefix) {
+		return ""
+	}
+	return line[len(prefix):]
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"os"
+	"path"
+	"strings"
+)
+
+// toolchain is a Go installation harnesses are built with. The default
+// toolchain is the one in $GOROOT (and $GOBIN); -toolchains names others
+// for A/B comparisons.
+type toolchain struct {
+	name   string
+	goroot string
+}
+
+var toolchainList string
+
+var currentToolchain = &toolchain{name: "default"}
+
+func toolchainFlag() {
+	flag.StringVar(&toolchainList, "toolchains", "", "space separated name=GOROOT pairs to run and compare, e.g. \"release=/usr/local/go tip=$HOME/go\"")
+}
+
+// toolchains returns the toolchains named by -toolchains, or only the
+// default one.
+func toolchains() ([]*toolchain, os.Error) {
+	fields := strings.Fields(toolchainList)
+	if len(fields) == 0 {
+		return []*toolchain{&toolchain{"default", GOROOT}}, nil
+	}
+	list := make([]*toolchain, 0, len(fields))
+	for _, field := range fields {
+		eq := strings.Index(field, "=")
+		if eq <= 0 || field[:eq] == "default" {
+			return nil, os.NewError("bad toolchain " + field + ", want name=GOROOT")
+		}
+		goroot := field[eq+1:]
+		if !path.IsAbs(goroot) {
+			cwd, err := os.Getwd()
+			if err != nil {
+				return nil, err
+			}
+			goroot = path.Join(cwd, goroot)
+		}
+		list = append(list, &toolchain{field[:eq], path.Clean(goroot)})
+	}
+	return list, nil
+}
+
+// useToolchain makes tc the toolchain packages are discovered in and
+// harnesses are built with.
+func useToolchain(tc *toolchain) {
+	currentToolchain = tc
+	GOROOT = tc.goroot
+}
+
+// suffix tells the files of named toolchains apart from those of the
+// default one, which keep their historical names.
+func (tc *toolchain) suffix() string {
+	if tc.name == "default" {
+		return ""
+	}
+	return "." + tc.name
+}
+
+// workspace is the GOROOT harnesses of tc are built against.
+func (tc *toolchain) workspace(cwd string) string {
+	return path.Join(cwd, "go.gostress"+tc.suffix())
+}
+
+func (tc *toolchain) checkpointFile() string {
+	return "survey" + tc.suffix() + ".checkpoint"
+}
+
+func (tc *toolchain) historyFile() string {
+	return "survey" + tc.suffix() + ".history"
+}
+
+func (tc *toolchain) reportDir() string {
+	return "report" + tc.suffix()
+}
+
+func (tc *toolchain) tools() (compiler, linker string) {
+	if tc.name == "default" {
+		return toolchainTools()
+	}
+	compilerExec, linkerExec := "8g", "8l"
+	if os.Getenv("GOARCH") == "amd64" {
+		compilerExec, linkerExec = "6g", "6l"
+	}
+	return path.Join(tc.goroot, "bin", compilerExec), path.Join(tc.goroot, "bin", linkerExec)
+}
+
+// fisherExact returns the two-sided p-value of Fisher's exact test for
+// failures1 of runs1 against failures2 of runs2.
+func fisherExact(failures1, runs1, failures2, runs2 int) float64 {
+	failures := failures1 + failures2
+	total := runs1 + runs2
+	logChoose := func(n, k int) float64 {
+		a, _ := math.Lgamma(float64(n + 1))
+		b, _ := math.Lgamma(float64(k + 1))
+		c, _ := math.Lgamma(float64(n - k + 1))
+		return a - b - c
+	}
+	prob := func(k int) float64 {
+		return math.Exp(logChoose(runs1, k) + logChoose(runs2, failures-k) - logChoose(total, failures))
+	}
+	observed := prob(failures1)
+	p := 0.0
+	lo := failures - runs2
+	if lo < 0 {
+		lo = 0
+	}
+	hi := failures
+	if hi > runs1 {
+		hi = runs1
+	}
+	for k := lo; k <= hi; k++ {
+		if pk := prob(k); pk <= observed*(1+1e-7) {
+			p += pk
+		}
+	}
+	if p > 1 {
+		p = 1
+	}
+	return p
+}